@@ -0,0 +1,318 @@
+package bit
+
+import "math/bits"
+
+// A chain is a subber for a subtree whose top levels are nodes with a single
+// subnode each. It takes the place of the node at the given shift, and leads
+// straight to sub, the first subtree below that is not such a node; prefix
+// holds the indices of the nodes it skips. This is the path compression of
+// adaptive radix trees, applied below the root. (The single-subnode nodes
+// above the root are stored in the SparseSet's prefix instead.)
+//
+// compact replaces a node with a single subnode by a chain. node.add splits a
+// chain when it adds an element off the chain's path, and the operations on
+// two subtrees expand a chain back into a node, one level at a time, when the
+// other subtree does not have the same path.
+type chain struct {
+	shift uint
+	// prefix holds the bits of every element of the chain from the level of
+	// sub up to the level of the chain. The other bits are zero.
+	prefix uint64
+	sub    subber // a *node, *Set256 or full, at a lower level than the chain
+}
+
+// level returns the number of low bits of an element that s and its
+// descendants index.
+func level(s subber) uint {
+	switch s := s.(type) {
+	case *node:
+		return s.shift + 8
+	case full:
+		return s.shift + 8
+	case *chain:
+		return s.shift + 8
+	}
+	return 8
+}
+
+// newChain returns a subber that takes the place of a node at shift, holding
+// the elements of sub. The bits of prefix between the levels of sub and the
+// node are the indices on the path to sub. If sub is at the level just below
+// the node, newChain returns sub; if sub is a chain, it is absorbed.
+func newChain(shift uint, prefix uint64, sub subber) subber {
+	if level(sub) == shift+8 {
+		return sub
+	}
+	c := &chain{shift: shift, prefix: prefix, sub: sub}
+	if s, ok := sub.(*chain); ok {
+		c.prefix = prefix&^(uint64(1)<<(s.shift+8)-1) | s.prefix
+		c.sub = s.sub
+	}
+	c.prefix &= c.pathMask()
+	return c
+}
+
+// singleton returns a subber holding only e, that takes the place of a node
+// at shift, or of a leaf if shift is zero.
+func singleton(shift uint, e uint64) subber {
+	leaf := &Set256{}
+	leaf.Add(uint8(e))
+	return newChain(shift, e, leaf)
+}
+
+// pathMask selects the bits of an element that c's prefix holds.
+func (c *chain) pathMask() uint64 {
+	return (uint64(1)<<(c.shift+8) - 1) &^ (uint64(1)<<level(c.sub) - 1)
+}
+
+// covers reports whether e is on c's path, so that c could hold it.
+// The bits of e above c's level are ignored.
+func (c *chain) covers(e uint64) bool {
+	return (e^c.prefix)&c.pathMask() == 0
+}
+
+// base returns the smallest element that c can hold, with the bits of x
+// above c's level.
+func (c *chain) base(x uint64) uint64 {
+	return x&^(uint64(1)<<(c.shift+8)-1) | c.prefix
+}
+
+// span returns the smallest and largest elements that c can hold, with the
+// bits of x above c's level.
+func (c *chain) span(x uint64) (lo, hi uint64) {
+	lo = c.base(x)
+	return lo, lo | (uint64(1)<<level(c.sub) - 1)
+}
+
+// setSub replaces the subtree at the bottom of c with s, which is at the same
+// level, absorbing s if it is a chain.
+func (c *chain) setSub(s subber) {
+	if d, ok := s.(*chain); ok {
+		c.prefix |= d.prefix
+		s = d.sub
+	}
+	c.sub = s
+}
+
+// expand returns a node equivalent to c, with a single subnode that holds the
+// rest of the chain. It shares c's sub.
+func (c *chain) expand() *node {
+	index := uint8(c.prefix >> c.shift)
+	n := &node{
+		shift:    c.shift,
+		count:    c.sub.size(),
+		subnodes: []subnode{{index: index, sub: newChain(c.shift-8, c.prefix, c.sub)}},
+	}
+	n.bitset.Add(index)
+	return n
+}
+
+// split returns a subber holding the elements of c and e, which must not be
+// on c's path. It has a node where the paths of c and e diverge. split does
+// not modify c, but the result shares c's sub.
+func (c *chain) split(e uint64) subber {
+	diff := (e ^ c.prefix) & c.pathMask()
+	shift := uint(bits.Len64(diff)-1) / 8 * 8
+	n := &node{shift: shift, count: addCounts(c.size(), 1)}
+	old := subnode{index: uint8(c.prefix >> shift), sub: newChain(shift-8, c.prefix, c.sub)}
+	sn := subnode{index: uint8(e >> shift), sub: singleton(shift-8, e)}
+	if old.index < sn.index {
+		n.subnodes = []subnode{old, sn}
+	} else {
+		n.subnodes = []subnode{sn, old}
+	}
+	n.bitset.Add(old.index)
+	n.bitset.Add(sn.index)
+	return newChain(c.shift, c.prefix, n)
+}
+
+// root returns a node that can be the root of a tree holding the elements of
+// c, and the prefix of that tree. The bits of high above c's level are the
+// high bits of c's elements.
+func (c *chain) root(high uint64) (*node, uint64) {
+	high |= c.prefix
+	if n, ok := c.sub.(*node); ok {
+		return n, high &^ n.mask()
+	}
+	// A leaf or full cannot be a root, so keep the last node of the chain.
+	shift := level(c.sub)
+	index := uint8(c.prefix >> shift)
+	n := &node{shift: shift, count: c.sub.size(), subnodes: []subnode{{index: index, sub: c.sub}}}
+	n.bitset.Add(index)
+	return n, high &^ n.mask()
+}
+
+// add adds e, which must be on c's path. node.add splits c otherwise.
+func (c *chain) add(e uint64) bool {
+	if !c.covers(e) {
+		panic("chain.add: must split first")
+	}
+	if !c.sub.add(e) {
+		return false
+	}
+	c.sub = compact(c.sub)
+	return true
+}
+
+func (c *chain) remove(e uint64) (removed, empty bool) {
+	if !c.covers(e) {
+		return false, false
+	}
+	if f, ok := c.sub.(full); ok {
+		c.sub = f.expand()
+	}
+	removed, empty = c.sub.remove(e)
+	if removed && !empty {
+		c.setSub(compact(c.sub))
+	}
+	return removed, empty
+}
+
+func (c *chain) contains(e uint64) bool {
+	return c.covers(e) && c.sub.contains(e)
+}
+
+func (c *chain) rank(e uint64) int {
+	switch p := e & c.pathMask(); {
+	case p < c.prefix:
+		return 0
+	case p > c.prefix:
+		return c.size()
+	}
+	return c.sub.rank(e)
+}
+
+func (c *chain) nth(k int) uint64 { return c.prefix | c.sub.nth(k) }
+
+func (c *chain) elements(a []uint64, start, high uint64) int {
+	switch p := start & c.pathMask(); {
+	case p < c.prefix:
+		start = 0
+	case p > c.prefix:
+		return 0
+	}
+	return c.sub.elements(a, start, high|c.prefix)
+}
+
+func (c *chain) size() int { return c.sub.size() }
+
+func (c *chain) memSize() uint64 {
+	return allocSize(memSize(*c)) + c.sub.memSize()
+}
+
+func (c *chain) equalSub(s subber) bool {
+	switch s := s.(type) {
+	case *chain:
+		if level(s.sub) == level(c.sub) {
+			return s.prefix == c.prefix && c.sub.equalSub(s.sub)
+		}
+		return c.expand().equal(s.expand())
+	case *node:
+		return c.expand().equal(s)
+	}
+	// A full has more than one subnode.
+	return false
+}
+
+func (c *chain) copySub() subber {
+	return &chain{shift: c.shift, prefix: c.prefix, sub: c.sub.copySub()}
+}
+
+func (c *chain) addRangeSub(lo, hi uint64) subber {
+	if slo, shi := c.span(lo); slo <= lo && hi <= shi {
+		c.setSub(c.sub.addRangeSub(lo, hi))
+		return c
+	}
+	return c.expand().addRangeSub(lo, hi)
+}
+
+func (c *chain) removeRangeSub(lo, hi uint64) subber {
+	slo, shi := c.span(lo)
+	lo, hi = max(lo, slo), min(hi, shi)
+	if lo > hi {
+		return c
+	}
+	sub := c.sub.removeRangeSub(lo, hi)
+	if sub == nil {
+		return nil
+	}
+	c.setSub(compact(sub))
+	return c
+}
+
+func (c *chain) flipRangeSub(lo, hi uint64) subber {
+	if slo, shi := c.span(lo); slo <= lo && hi <= shi {
+		sub := c.sub.flipRangeSub(lo, hi)
+		if sub == nil {
+			return nil
+		}
+		c.setSub(compact(sub))
+		return c
+	}
+	return c.expand().flipRangeSub(lo, hi)
+}
+
+func (c *chain) containsAll(lo, hi uint64) bool {
+	slo, shi := c.span(lo)
+	return slo <= lo && hi <= shi && c.sub.containsAll(lo, hi)
+}
+
+func (c *chain) countRange(lo, hi uint64) int {
+	slo, shi := c.span(lo)
+	lo, hi = max(lo, slo), min(hi, shi)
+	if lo > hi {
+		return 0
+	}
+	return c.sub.countRange(lo, hi)
+}
+
+// The binary operations below are only called with a chain that has the same
+// path as the receiver; see unchain.
+
+func (c *chain) intersectionSizeSub(s subber) int {
+	return intersectionSize(c.sub, s.(*chain).sub)
+}
+
+func (c *chain) subsetSub(s subber) bool { return isSubset(c.sub, s.(*chain).sub) }
+
+func (c *chain) intersectsSub(s subber) bool { return intersects(c.sub, s.(*chain).sub) }
+
+func (c *chain) unionWithSub(s subber) {
+	c.setSub(unionSub(c.sub, s.(*chain).sub))
+}
+
+func (c *chain) differenceWithSub(s subber) bool {
+	sub := differenceSub(c.sub, s.(*chain).sub)
+	if sub == nil {
+		return true
+	}
+	c.setSub(sub)
+	return false
+}
+
+func (c *chain) symmetricDifferenceWithSub(s subber) bool {
+	sub := symmetricDifferenceSub(c.sub, s.(*chain).sub)
+	if sub == nil {
+		return true
+	}
+	c.setSub(sub)
+	return false
+}
+
+// unchain returns a and b, subbers at the same level, ready for an operation
+// that needs them to have the same dynamic type. Chains are expanded into
+// nodes, unless both are chains with the same path.
+func unchain(a, b subber) (subber, subber) {
+	ca, aok := a.(*chain)
+	cb, bok := b.(*chain)
+	if aok && bok && ca.prefix == cb.prefix && level(ca.sub) == level(cb.sub) {
+		return a, b
+	}
+	if aok {
+		a = ca.expand()
+	}
+	if bok {
+		b = cb.expand()
+	}
+	return a, b
+}
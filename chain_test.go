@@ -0,0 +1,326 @@
+package bit
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// checkTree reports an error if the tree of s is not as compact as it should
+// be: every node below the root must have more than one subnode, a chain
+// must not end in another chain, and counts must be right.
+func checkTree(t *testing.T, s *SparseSet) {
+	t.Helper()
+	if s.root == nil {
+		return
+	}
+	var walk func(sub subber, root bool) int
+	walk = func(sub subber, root bool) int {
+		switch sub := sub.(type) {
+		case *node:
+			if !root && len(sub.subnodes) < 2 {
+				t.Fatalf("node at shift %d has %d subnodes", sub.shift, len(sub.subnodes))
+			}
+			n := 0
+			for _, sn := range sub.subnodes {
+				if level(sn.sub) != sub.shift {
+					t.Fatalf("subnode at level %d below node at shift %d", level(sn.sub), sub.shift)
+				}
+				n += walk(sn.sub, false)
+			}
+			if n != sub.count {
+				t.Fatalf("node at shift %d has count %d, want %d", sub.shift, sub.count, n)
+			}
+			return n
+		case *chain:
+			switch sub.sub.(type) {
+			case *chain:
+				t.Fatal("chain ends in a chain")
+			case *node:
+				if level(sub.sub) >= sub.shift+8 {
+					t.Fatalf("chain at shift %d ends at level %d", sub.shift, level(sub.sub))
+				}
+			}
+			if sub.prefix&^sub.pathMask() != 0 {
+				t.Fatalf("chain prefix %#x has bits off its path", sub.prefix)
+			}
+			return walk(sub.sub, false)
+		}
+		return sub.size()
+	}
+	walk(s.root, true)
+}
+
+func TestChain(t *testing.T) {
+	// Two clusters of elements near 2^60, and one element near zero. Each
+	// child of the root is a chain.
+	const base = 1 << 60
+	els := []uint64{5, base + 3, base + 300, base + 1<<40 + 7, base + 1<<40 + 70000}
+	s := NewSparseSet(els...)
+	checkTree(t, s)
+	st := s.Stats()
+	if got, want := len(st.Levels), 7; got != want {
+		t.Fatalf("got %d levels, want %d", got, want)
+	}
+	if l := st.Levels[0]; l.Nodes != 1 || l.Children != 2 || l.Chains != 2 {
+		t.Errorf("root: %+v", l)
+	}
+	nodes := 0
+	for _, l := range st.Levels {
+		nodes += l.Nodes
+	}
+	// The root, the node where the clusters near 2^60 diverge, and the
+	// node where each of those clusters branches.
+	if nodes != 4 {
+		t.Errorf("%d nodes, want 4: %+v", nodes, st.Levels)
+	}
+	for _, e := range els {
+		if !s.Contains(e) {
+			t.Errorf("missing %#x", e)
+		}
+	}
+	for _, e := range []uint64{0, 4, base, base + 4, base + 1<<32 + 3, base + 1<<40 + 3, 1 << 63} {
+		if s.Contains(e) {
+			t.Errorf("Contains(%#x)", e)
+		}
+	}
+	if got := slices.Collect(s.All()); !cmp.Equal(got, els) {
+		t.Errorf("All: got %v", got)
+	}
+	a := make([]uint64, 10)
+	if n := s.Elements(a, base+4); !cmp.Equal(a[:n], els[2:]) {
+		t.Errorf("Elements: got %v", a[:n])
+	}
+	if n := s.Elements(a, base+1<<40+8); !cmp.Equal(a[:n], els[4:]) {
+		t.Errorf("Elements past a chain: got %v", a[:n])
+	}
+
+	// Adding an element off a chain's path splits the chain; removing it
+	// joins the chain again.
+	before := s.MemSize()
+	s.Add(base + 1<<24)
+	checkTree(t, s)
+	if !s.Contains(base+1<<24) || s.Size() != len(els)+1 {
+		t.Errorf("after Add: %s", s)
+	}
+	s.Remove(base + 1<<24)
+	checkTree(t, s)
+	if got := slices.Collect(s.All()); !cmp.Equal(got, els) {
+		t.Errorf("after Remove: got %v", got)
+	}
+	if after := s.MemSize(); after != before {
+		t.Errorf("MemSize = %d after Add and Remove, want %d", after, before)
+	}
+
+	// Intersecting with a set that has a different path only at the bottom
+	// of the chain.
+	var in SparseSet
+	in.Intersect(s, NewSparseSet(base+3, base+1<<8+3, base+1<<40+70000))
+	checkTree(t, &in)
+	if got := slices.Collect(in.All()); !cmp.Equal(got, []uint64{base + 3, base + 1<<40 + 70000}) {
+		t.Errorf("Intersect: got %v", got)
+	}
+}
+
+func TestChainMemSize(t *testing.T) {
+	// Elements far apart each take a chain and a leaf, instead of a node at
+	// each level between the root and the leaf.
+	s := NewSparseSet(1<<56, 2<<56, 3<<56)
+	want := s.root.memSize() - 3*(allocSize(memSize(chain{}))+allocSize(memSize(Set256{})))
+	if got := allocSize(memSize(node{})) + allocSize(3*memSize(subnode{})); want != got {
+		t.Errorf("root alone takes %d bytes, want %d", want, got)
+	}
+}
+
+// clusteredSet returns a SparseSet of random elements in a few clusters
+// scattered over the uint64 range, and its elements in order.
+func clusteredSet(r *rand.Rand) (*SparseSet, []uint64) {
+	s := &SparseSet{}
+	bases := []uint64{0, 1 << 60, 1<<60 + 1<<40}
+	for range 3 {
+		bases = append(bases, r.Uint64())
+	}
+	for range 1 + r.Intn(40) {
+		base := bases[r.Intn(len(bases))]
+		spread := uint64(1) << (8 * r.Intn(4))
+		s.Add(base + r.Uint64()%spread)
+	}
+	return s, slices.Collect(s.All())
+}
+
+func TestChainsRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		s1, els1 := clusteredSet(r)
+		s2, els2 := clusteredSet(r)
+		checkTree(t, s1)
+		in := map[uint64]int{}
+		for _, e := range els1 {
+			in[e] |= 1
+		}
+		for _, e := range els2 {
+			in[e] |= 2
+		}
+		want := func(f func(a, b bool) bool) *SparseSet {
+			var es []uint64
+			for e, b := range in {
+				if f(b&1 != 0, b&2 != 0) {
+					es = append(es, e)
+				}
+			}
+			slices.Sort(es)
+			return NewSparseSetFromSorted(es)
+		}
+		check := func(name string, got, want *SparseSet) {
+			t.Helper()
+			checkTree(t, got)
+			if !got.Equal(want) || got.Compare(want) != 0 || got.Hash() != want.Hash() {
+				t.Fatalf("%s(%s, %s): got %s, want %s", name, s1, s2, got, want)
+			}
+		}
+		union := want(func(a, b bool) bool { return a || b })
+		inter := want(func(a, b bool) bool { return a && b })
+		diff := want(func(a, b bool) bool { return a && !b })
+		xor := want(func(a, b bool) bool { return a != b })
+
+		u := s1.Copy()
+		u.UnionWith(s2)
+		check("UnionWith", u, union)
+		d := s1.Copy()
+		d.DifferenceWith(s2)
+		check("DifferenceWith", d, diff)
+		x := s1.Copy()
+		x.SymmetricDifferenceWith(s2)
+		check("SymmetricDifferenceWith", x, xor)
+		var n SparseSet
+		n.Intersect(s1, s2)
+		check("Intersect", &n, inter)
+		if got, want := s1.IntersectionSize(s2), inter.Size(); got != want {
+			t.Fatalf("IntersectionSize = %d, want %d", got, want)
+		}
+		if got, want := s1.IsSubsetOf(s2), diff.Empty(); got != want {
+			t.Fatalf("IsSubsetOf = %t, want %t", got, want)
+		}
+		if got, want := s1.Intersects(s2), !inter.Empty(); got != want {
+			t.Fatalf("Intersects = %t, want %t", got, want)
+		}
+		if got, want := s1.Compare(s2), slices.Compare(els1, els2); got != want {
+			t.Fatalf("Compare = %d, want %d", got, want)
+		}
+
+		p1, p2 := s1.Persistent(), s2.Persistent()
+		check("PersistentSet.Union", &p1.Union(p2).s, union)
+		check("PersistentSet.Intersect", &p1.Intersect(p2).s, inter)
+		check("PersistentSet.Difference", &p1.Difference(p2).s, diff)
+		check("PersistentSet.SymmetricDifference", &p1.SymmetricDifference(p2).s, xor)
+		p := p1
+		for _, e := range els2 {
+			p = p.Add(e)
+		}
+		check("PersistentSet.Add", &p.s, union)
+		for _, e := range els2 {
+			p = p.Remove(e)
+		}
+		check("PersistentSet.Remove", &p.s, diff)
+		check("PersistentSet unchanged", &p1.s, s1)
+
+		// Remove the elements of s2 from the union one at a time.
+		for _, e := range els2 {
+			u.Remove(e)
+		}
+		check("Remove", u, diff)
+
+		for k, e := range els1 {
+			if got := s1.Rank(e); got != k {
+				t.Fatalf("Rank(%#x) = %d, want %d", e, got, k)
+			}
+			if got, _ := s1.Select(k); got != e {
+				t.Fatalf("Select(%d) = %#x, want %#x", k, got, e)
+			}
+			if got, ok := s1.NextAfter(e); ok != (k+1 < len(els1)) || ok && got != els1[k+1] {
+				t.Fatalf("NextAfter(%#x) = %#x, %t", e, got, ok)
+			}
+			if got, ok := s1.PrevBefore(e); ok != (k > 0) || ok && got != els1[k-1] {
+				t.Fatalf("PrevBefore(%#x) = %#x, %t", e, got, ok)
+			}
+			if got := slices.Collect(s1.From(e)); !cmp.Equal(got, els1[k:]) {
+				t.Fatalf("From(%#x) = %v", e, got)
+			}
+			if got := s1.Count(e, e+1<<20); got != countBetween(els1, e, e+1<<20) {
+				t.Fatalf("Count(%#x, +2^20) = %d", e, got)
+			}
+		}
+		if got := slices.Collect(s1.Backward()); !cmp.Equal(got, reversed(els1)) {
+			t.Fatalf("Backward = %v", got)
+		}
+
+		// Ranges within and around the clusters.
+		e := els1[r.Intn(len(els1))]
+		lo := e - min(e, uint64(r.Intn(1000)))
+		hi := lo + uint64(r.Intn(1<<17))
+		rs := rangeSet(lo, hi+1)
+		var rdiff SparseSet
+		rdiff.Difference(s1, rs)
+		ranges := []struct {
+			name string
+			op   func(*SparseSet, uint64, uint64)
+			want func(*SparseSet)
+		}{
+			{"AddRange", (*SparseSet).AddRange, func(w *SparseSet) { w.UnionWith(rs) }},
+			{"RemoveRange", (*SparseSet).RemoveRange, func(w *SparseSet) { w.DifferenceWith(rs) }},
+			{"FlipRange", (*SparseSet).FlipRange, func(w *SparseSet) { w.SymmetricDifferenceWith(rs) }},
+		}
+		for _, rg := range ranges {
+			got := s1.Copy()
+			rg.op(got, lo, hi)
+			want := s1.Copy()
+			rg.want(want)
+			check(rg.name, got, want)
+			if rg.name == "AddRange" && !got.ContainsAll(lo, hi) {
+				t.Fatalf("ContainsAll(%#x, %#x) after AddRange", lo, hi)
+			}
+			if got, want := s1.Count(lo, hi), s1.Size()-rdiff.Size(); got != want {
+				t.Fatalf("Count(%#x, %#x) = %d, want %d", lo, hi, got, want)
+			}
+		}
+		if got, want := s1.ContainsAll(lo, hi), rdiff.Size()+rs.Size() == s1.Size(); got != want {
+			t.Fatalf("ContainsAll(%#x, %#x) = %t", lo, hi, got)
+		}
+
+		var t1, t2 SparseSet
+		data, err := s1.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := t1.UnmarshalBinary(data); err != nil {
+			t.Fatal(err)
+		}
+		check("binary round trip", &t1, s1)
+		data, err = s1.MarshalRoaring64()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := t2.UnmarshalRoaring64(data); err != nil {
+			t.Fatal(err)
+		}
+		check("Roaring round trip", &t2, s1)
+	}
+}
+
+func countBetween(els []uint64, lo, hi uint64) int {
+	n := 0
+	for _, e := range els {
+		if lo <= e && e <= hi {
+			n++
+		}
+	}
+	return n
+}
+
+func reversed(els []uint64) []uint64 {
+	r := slices.Clone(els)
+	slices.Reverse(r)
+	return r
+}
@@ -0,0 +1,210 @@
+package bit
+
+import "sync/atomic"
+
+// Compare compares s1 and s2 lexicographically, as sequences of their elements
+// in increasing order, and returns -1, 0 or +1. That is the result of
+// slices.Compare on their elements, but Compare walks the two trees together
+// and does not visit subtrees past the first difference. The empty set
+// is less than any other set.
+// The method expression (*SparseSet).Compare can be passed to slices.SortFunc.
+func (s1 *SparseSet) Compare(s2 *SparseSet) int {
+	switch {
+	case s1.root == nil && s2.root == nil:
+		return 0
+	case s1.root == nil:
+		return -1
+	case s2.root == nil:
+		return 1
+	}
+	shift := commonRootShift(s1, s2)
+	n1, _ := s1.lifted(shift)
+	n2, _ := s2.lifted(shift)
+	return max(-1, min(compareSub(n1, n2), 1))
+}
+
+// compareSub compares the elements of a and b, which are non-empty subbers at
+// the same level. It returns -1 or +1 if they differ at some position, as
+// Compare does; -2 if the elements of a are a proper prefix of those of b;
+// +2 if those of b are a proper prefix of those of a; and 0 if they are
+// equal. The caller needs to tell a prefix from a difference, because the
+// elements that follow a and b in their sets decide the order of a prefix.
+func compareSub(a, b subber) int {
+	if a == b {
+		return 0
+	}
+	if f, ok := a.(full); ok {
+		a = f.expand()
+	}
+	if f, ok := b.(full); ok {
+		b = f.expand()
+	}
+	a, b = unchain(a, b)
+	switch a := a.(type) {
+	case *Set256:
+		return a.compare(b.(*Set256))
+	case *chain:
+		return compareSub(a.sub, b.(*chain).sub)
+	}
+	return a.(*node).compare(b.(*node))
+}
+
+func (n1 *node) compare(n2 *node) int {
+	sns1, sns2 := n1.subnodes, n2.subnodes
+	for len(sns1) > 0 && len(sns2) > 0 {
+		sn1, sn2 := sns1[0], sns2[0]
+		switch {
+		case sn1.index < sn2.index:
+			return -1
+		case sn1.index > sn2.index:
+			return 1
+		}
+		sns1, sns2 = sns1[1:], sns2[1:]
+		switch c := compareSub(sn1.sub, sn2.sub); c {
+		case 0:
+		case -2:
+			// The next element of n1, if any, is in a later subtree, so it
+			// is greater than the next element of n2.
+			if len(sns1) > 0 {
+				return 1
+			}
+			return -2
+		case 2:
+			if len(sns2) > 0 {
+				return -1
+			}
+			return 2
+		default:
+			return c
+		}
+	}
+	switch {
+	case len(sns1) > 0:
+		return 2
+	case len(sns2) > 0:
+		return -2
+	}
+	return 0
+}
+
+// compare is like compareSub for two leaves.
+func (s1 *Set256) compare(s2 *Set256) int {
+	for i := range s1.sets {
+		d := s1.sets[i] ^ s2.sets[i]
+		if d == 0 {
+			continue
+		}
+		// e is the smallest element in only one of the sets.
+		e, _ := d.Min()
+		e += uint8(i * 64)
+		if s1.Contains(e) {
+			if _, ok := s2.NextAfter(e); ok {
+				return -1
+			}
+			return 2
+		}
+		if _, ok := s1.NextAfter(e); ok {
+			return 1
+		}
+		return -2
+	}
+	return 0
+}
+
+// Hash returns a hash of the elements of s. Equal sets have equal hashes,
+// however their trees were built. The hash is not cryptographically secure,
+// and may change from one version of this package to the next, so it should
+// not be stored.
+//
+// Each node caches its hash, and the cache is cleared along the path to any
+// change. So after the first call, Hash takes time in proportion to the
+// number of nodes changed since the previous call.
+func (s *SparseSet) Hash() uint64 {
+	if s.root == nil {
+		return 0
+	}
+	// Hash as if the tree had its full height, with the prefix stored in a
+	// chain of nodes with one subnode each.
+	h := s.root.hash()
+	for shift := s.root.shift + 8; shift < 64; shift += 8 {
+		h = hashEnd(hashSubnode(hashStart(shift), uint8(s.prefix>>shift), h))
+	}
+	return h
+}
+
+// The hash of a leaf combines its words. The hash of a node combines its
+// shift with the index and hash of each subnode. A full or chain has the hash
+// of the nodes it replaces. A hash is never zero, so that a node can use zero
+// to mean that it has not cached its hash.
+
+const hashMul = 0x9e3779b97f4a7c15
+
+// hashMix combines h with v.
+func hashMix(h, v uint64) uint64 {
+	h = (h ^ v) * hashMul
+	return h ^ h>>29
+}
+
+func hashStart(shift uint) uint64 { return hashMix(0x6a09e667f3bcc908, uint64(shift)) }
+
+func hashSubnode(h uint64, index uint8, subHash uint64) uint64 {
+	return hashMix(hashMix(h, uint64(index)), subHash)
+}
+
+func hashEnd(h uint64) uint64 {
+	if h == 0 {
+		return 1
+	}
+	return h
+}
+
+// hash returns the hash of n, computing and caching it if necessary. Since
+// the nodes of a PersistentSet may be shared among goroutines, the cache is
+// read and written atomically.
+func (n *node) hash() uint64 {
+	if h := atomic.LoadUint64(&n.hashCache); h != 0 {
+		return h
+	}
+	h := hashStart(n.shift)
+	for _, sn := range n.subnodes {
+		h = hashSubnode(h, sn.index, sn.sub.hash())
+	}
+	h = hashEnd(h)
+	atomic.StoreUint64(&n.hashCache, h)
+	return h
+}
+
+func (s *Set256) hash() uint64 {
+	h := uint64(0xbb67ae8584caa73b)
+	for _, w := range s.sets {
+		h = hashMix(h, uint64(w))
+	}
+	return hashEnd(h)
+}
+
+func (f full) hash() uint64 { return fullHashes[f.shift/8] }
+
+// hash is not cached, since it takes time only in proportion to the length
+// of c, plus the hash of c's sub.
+func (c *chain) hash() uint64 {
+	h := c.sub.hash()
+	for shift := level(c.sub); shift <= c.shift; shift += 8 {
+		h = hashEnd(hashSubnode(hashStart(shift), uint8(c.prefix>>shift), h))
+	}
+	return h
+}
+
+// fullHashes[i] is the hash of a full node at shift 8*i.
+var fullHashes = func() [8]uint64 {
+	var hs [8]uint64
+	sub := fullSet256().hash()
+	for i := 1; i < len(hs); i++ {
+		h := hashStart(uint(8 * i))
+		for j := 0; j < 256; j++ {
+			h = hashSubnode(h, uint8(j), sub)
+		}
+		hs[i] = hashEnd(h)
+		sub = hs[i]
+	}
+	return hs
+}()
@@ -0,0 +1,179 @@
+package bit
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+// compareSets is a collection of sets that differ in small ways, so that
+// comparing them finds differences at every level and in every position.
+func compareSets() []*SparseSet {
+	base := []*SparseSet{
+		{},
+		NewSparseSet(0),
+		NewSparseSet(1),
+		NewSparseSet(1, 2),
+		NewSparseSet(1, 3),
+		NewSparseSet(1, 2, 300),
+		NewSparseSet(1, 300),
+		NewSparseSet(2, 300),
+		NewSparseSet(1, 2, 1<<40),
+		NewSparseSet(1<<40, 1<<40+1),
+		NewSparseSet(1<<60, 1<<60+70000),
+		rangeSet(0, 1<<16),
+		rangeSet(0, 1<<16+1),
+		rangeSet(1, 1<<16),
+		randSparseSet(100),
+	}
+	var sets []*SparseSet
+	for _, s := range base {
+		sets = append(sets, s)
+		if m, ok := s.Max(); ok {
+			// The same set without its last element, and with another
+			// element after its last.
+			t := s.Copy()
+			t.Remove(m)
+			u := s.Copy()
+			u.Add(m + 1000)
+			sets = append(sets, t, u)
+		}
+	}
+	return sets
+}
+
+func TestCompare(t *testing.T) {
+	sets := compareSets()
+	for _, s1 := range sets {
+		e1 := slices.Collect(s1.All())
+		for _, s2 := range sets {
+			want := slices.Compare(e1, slices.Collect(s2.All()))
+			if got := s1.Compare(s2); got != want {
+				t.Errorf("%s.Compare(%s) = %d, want %d", s1, s2, got, want)
+			}
+		}
+	}
+	// Sorting with Compare orders sets like their element slices.
+	slices.SortFunc(sets, (*SparseSet).Compare)
+	for i := 1; i < len(sets); i++ {
+		if slices.Compare(slices.Collect(sets[i-1].All()), slices.Collect(sets[i].All())) > 0 {
+			t.Errorf("%s sorted before %s", sets[i-1], sets[i])
+		}
+	}
+}
+
+func TestCompareLeaves(t *testing.T) {
+	// Exercise the prefix cases of Set256.compare.
+	for i := 0; i < 1000; i++ {
+		var s1, s2 SparseSet
+		for j := rand.Intn(8); j > 0; j-- {
+			s1.Add(uint64(rand.Intn(512)))
+			s2.Add(uint64(rand.Intn(512)))
+		}
+		want := slices.Compare(slices.Collect(s1.All()), slices.Collect(s2.All()))
+		if got := s1.Compare(&s2); got != want {
+			t.Fatalf("%s.Compare(%s) = %d, want %d", s1, s2, got, want)
+		}
+	}
+}
+
+func TestHash(t *testing.T) {
+	sets := compareSets()
+	for _, s1 := range sets {
+		for _, s2 := range sets {
+			// Different sets could have the same hash, but that is very
+			// unlikely for so few.
+			if s1.Equal(s2) != (s1.Hash() == s2.Hash()) {
+				t.Errorf("%s, %s: Equal is %t, but hashes %x, %x", s1, s2, s1.Equal(s2), s1.Hash(), s2.Hash())
+			}
+		}
+		// A set built another way has the same hash.
+		c := NewSparseSetFromSorted(slices.Collect(s1.All()))
+		if c.Hash() != s1.Hash() {
+			t.Errorf("%s: hash of rebuilt set differs", s1)
+		}
+	}
+
+	// A full subtree hashes like the equivalent nodes.
+	f := &SparseSet{}
+	f.AddRange(1<<20, 1<<20+2<<16-1)
+	if f.Stats().Levels[0].Full != 2 {
+		t.Fatal("no full subtree")
+	}
+	g := rangeSet(1<<20, 1<<20+2<<16)
+	g.Remove(1 << 20)
+	g.Add(1 << 20)
+	if f.Hash() != g.Hash() {
+		t.Error("full subtree hash differs")
+	}
+	r := f.root.shallowCopy(0)
+	r.subnodes[0].sub = r.subnodes[0].sub.(full).expand()
+	expanded := &SparseSet{root: r, prefix: f.prefix}
+	if expanded.Hash() != f.Hash() {
+		t.Error("expanded full hash differs")
+	}
+}
+
+func TestHashCache(t *testing.T) {
+	// After each change, the cached hash is the hash of a set built from
+	// scratch with the same elements.
+	s := randSparseSet(500)
+	check := func(what string) {
+		t.Helper()
+		want := NewSparseSetFromSorted(slices.Collect(s.All())).Hash()
+		if got := s.Hash(); got != want {
+			t.Errorf("after %s: stale hash", what)
+		}
+	}
+	check("start")
+	s.Add(17)
+	check("Add")
+	s.Remove(17)
+	check("Remove")
+	m, _ := s.Max()
+	s.Remove(m)
+	check("Remove of max")
+	s.AddRange(100, 5000)
+	check("AddRange")
+	s.RemoveRange(200, 300)
+	check("RemoveRange")
+	s.FlipRange(0, 1<<17)
+	check("FlipRange")
+	s.UnionWith(randSparseSet(100))
+	check("UnionWith")
+	s.DifferenceWith(randSparseSet(100))
+	check("DifferenceWith")
+	s.SymmetricDifferenceWith(randSparseSet(100))
+	check("SymmetricDifferenceWith")
+	s.Intersect(s, rangeSet(0, 3000))
+	check("Intersect")
+
+	// Persistent sets share nodes, and so their caches.
+	p := randSparseSet(500).Persistent()
+	h := p.Hash()
+	q := p.Add(12345).Remove(1)
+	if p.Hash() != h {
+		t.Error("p's hash changed")
+	}
+	if want := NewSparseSetFromSorted(slices.Collect(q.All())).Hash(); q.Hash() != want {
+		t.Error("q has a stale hash")
+	}
+	if r := q.Remove(12345).Add(1); r.Equal(p) != (r.Hash() == h) {
+		t.Error("r's hash disagrees with Equal")
+	}
+}
+
+func BenchmarkHash(b *testing.B) {
+	s := randSparseSet(10000)
+	b.Run("cached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			s.Hash()
+		}
+	})
+	b.Run("one change", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			s.Add(uint64(i))
+			s.Hash()
+		}
+	})
+}
@@ -0,0 +1,194 @@
+package bit
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// None of the other set types in this package are safe for concurrent use
+// when one goroutine modifies a set. The types in this file are.
+
+// An AtomicSet64 is like a Set64, but its methods are safe for concurrent use.
+// The zero value is an empty set.
+type AtomicSet64 struct {
+	w uint64
+}
+
+func (s *AtomicSet64) Add(u uint8) {
+	s.TestAndSet(u)
+}
+
+func (s *AtomicSet64) Remove(u uint8) {
+	s.TestAndClear(u)
+}
+
+func (s *AtomicSet64) Contains(u uint8) bool {
+	return atomic.LoadUint64(&s.w)&(1<<u) != 0
+}
+
+// TestAndSet adds u to s, and reports whether it was already there.
+// When several goroutines call TestAndSet with the same u, exactly one of them
+// observes false.
+func (s *AtomicSet64) TestAndSet(u uint8) bool {
+	mask := uint64(1) << u
+	for {
+		old := atomic.LoadUint64(&s.w)
+		if old&mask != 0 {
+			return true
+		}
+		if atomic.CompareAndSwapUint64(&s.w, old, old|mask) {
+			return false
+		}
+	}
+}
+
+// TestAndClear removes u from s, and reports whether it was there.
+func (s *AtomicSet64) TestAndClear(u uint8) bool {
+	mask := uint64(1) << u
+	for {
+		old := atomic.LoadUint64(&s.w)
+		if old&mask == 0 {
+			return false
+		}
+		if atomic.CompareAndSwapUint64(&s.w, old, old&^mask) {
+			return true
+		}
+	}
+}
+
+// Load returns the contents of s as a Set64.
+func (s *AtomicSet64) Load() Set64 {
+	return Set64(atomic.LoadUint64(&s.w))
+}
+
+// An AtomicSet is like a Set, but its methods are safe for concurrent use.
+// Its capacity is fixed when it is created.
+type AtomicSet struct {
+	sets []AtomicSet64
+}
+
+// NewAtomicSet creates a set capable of representing values in the range
+// [0, capacity), at least. It panics if capacity is negative.
+func NewAtomicSet(capacity int) *AtomicSet {
+	var n int
+	if capacity < 0 {
+		panic("negative capacity")
+	}
+	if capacity > 0 {
+		n = (capacity-1)/64 + 1
+	}
+	return &AtomicSet{sets: make([]AtomicSet64, n)}
+}
+
+func (s *AtomicSet) Capacity() int {
+	return len(s.sets) * 64
+}
+
+func (s *AtomicSet) Add(i int) {
+	u := uint(i)
+	s.sets[u/64].Add(uint8(u % 64))
+}
+
+func (s *AtomicSet) Remove(i int) {
+	u := uint(i)
+	s.sets[u/64].Remove(uint8(u % 64))
+}
+
+func (s *AtomicSet) Contains(i int) bool {
+	u := uint(i)
+	return s.sets[u/64].Contains(uint8(u % 64))
+}
+
+// TestAndSet adds i to s, and reports whether it was already there.
+// When several goroutines call TestAndSet with the same i, exactly one of them
+// observes false.
+func (s *AtomicSet) TestAndSet(i int) bool {
+	u := uint(i)
+	return s.sets[u/64].TestAndSet(uint8(u % 64))
+}
+
+// TestAndClear removes i from s, and reports whether it was there.
+func (s *AtomicSet) TestAndClear(i int) bool {
+	u := uint(i)
+	return s.sets[u/64].TestAndClear(uint8(u % 64))
+}
+
+// Load returns the contents of s as a Set. Each word is read atomically, but
+// if s is being modified concurrently the result may not reflect the
+// contents of s at any single moment.
+func (s *AtomicSet) Load() *Set {
+	t := &Set{sets: make([]Set64, len(s.sets))}
+	for i := range s.sets {
+		t.sets[i] = s.sets[i].Load()
+	}
+	return t
+}
+
+// A ConcurrentSparseSet is a SparseSet that is safe for concurrent use.
+//
+// Readers never wait: each read operation works on a consistent snapshot
+// of the set. Writers are serialized with a mutex. Each write builds a new
+// version of the tree, copying only the nodes on the path to the change (see
+// PersistentSet), and then publishes it atomically. So reads scale with the
+// number of goroutines, while writes cost a few allocations each.
+//
+// The zero value is an empty set. A ConcurrentSparseSet must not be copied
+// after first use.
+type ConcurrentSparseSet struct {
+	mu  sync.Mutex   // held by writers
+	cur atomic.Value // *PersistentSet
+}
+
+// Snapshot returns the current contents of s. It is not affected by later
+// changes to s.
+func (s *ConcurrentSparseSet) Snapshot() *PersistentSet {
+	if p, ok := s.cur.Load().(*PersistentSet); ok {
+		return p
+	}
+	return &PersistentSet{}
+}
+
+// Update replaces the contents of s with the result of f applied to the
+// current contents. Calls to Update and the other modifying methods are
+// serialized, so f sees the result of every earlier update.
+// Use Update to apply several changes at once.
+// If f returns nil, s becomes empty.
+func (s *ConcurrentSparseSet) Update(f func(*PersistentSet) *PersistentSet) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := f(s.Snapshot())
+	if p == nil {
+		p = &PersistentSet{}
+	}
+	s.cur.Store(p)
+}
+
+func (s *ConcurrentSparseSet) Add(n uint64) {
+	s.Update(func(p *PersistentSet) *PersistentSet { return p.Add(n) })
+}
+
+func (s *ConcurrentSparseSet) Remove(n uint64) {
+	s.Update(func(p *PersistentSet) *PersistentSet { return p.Remove(n) })
+}
+
+// TestAndSet adds n to s, and reports whether it was already there.
+func (s *ConcurrentSparseSet) TestAndSet(n uint64) bool {
+	var had bool
+	s.Update(func(p *PersistentSet) *PersistentSet {
+		had = p.Contains(n)
+		return p.Add(n)
+	})
+	return had
+}
+
+func (s *ConcurrentSparseSet) Contains(n uint64) bool {
+	return s.Snapshot().Contains(n)
+}
+
+func (s *ConcurrentSparseSet) Size() int {
+	return s.Snapshot().Size()
+}
+
+func (s *ConcurrentSparseSet) Empty() bool {
+	return s.Snapshot().Empty()
+}
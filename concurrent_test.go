@@ -0,0 +1,171 @@
+package bit
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestAtomicSet64(t *testing.T) {
+	var s AtomicSet64
+	if s.TestAndSet(3) {
+		t.Error("3 was already set")
+	}
+	if !s.TestAndSet(3) || !s.Contains(3) {
+		t.Error("3 not set")
+	}
+	s.Add(63)
+	if got, want := s.Load(), Set64(1<<3|1<<63); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	s.Remove(3)
+	if s.Contains(3) || s.TestAndClear(3) || !s.TestAndClear(63) {
+		t.Error("bad remove")
+	}
+}
+
+func TestAtomicSetConcurrent(t *testing.T) {
+	const n = 1000
+	const workers = 8
+	s := NewAtomicSet(n)
+	var wins int64
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				if !s.TestAndSet(i) {
+					atomic.AddInt64(&wins, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if wins != n {
+		t.Errorf("%d goroutines won, want %d", wins, n)
+	}
+	if got := s.Load().Size(); got != n {
+		t.Errorf("size %d, want %d", got, n)
+	}
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		w := w
+		go func() {
+			defer wg.Done()
+			for i := w; i < n; i += workers {
+				s.Remove(i)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := s.Load().Size(); got != 0 {
+		t.Errorf("size %d after removing all", got)
+	}
+}
+
+func TestConcurrentSparseSet(t *testing.T) {
+	const n = 2000
+	var s ConcurrentSparseSet
+	if !s.Empty() {
+		t.Fatal("zero value not empty")
+	}
+	var wg sync.WaitGroup
+	var wins int64
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := uint64(0); i < n; i++ {
+				if !s.TestAndSet(i * 1000) {
+					atomic.AddInt64(&wins, 1)
+				}
+			}
+		}()
+	}
+	// Readers see consistent snapshots: the size only grows, and a snapshot
+	// contains exactly the elements it counts.
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			last := 0
+			for last < n {
+				p := s.Snapshot()
+				size := p.Size()
+				if size < last {
+					t.Errorf("size went from %d to %d", last, size)
+					return
+				}
+				if got := len(drain(p.Iterator())); got != size {
+					t.Errorf("snapshot has %d elements, size %d", got, size)
+					return
+				}
+				last = size
+			}
+		}()
+	}
+	wg.Wait()
+	if wins != n || s.Size() != n {
+		t.Errorf("wins = %d, size = %d, want %d", wins, s.Size(), n)
+	}
+	snap := s.Snapshot()
+	s.Remove(0)
+	if s.Contains(0) || !snap.Contains(0) {
+		t.Error("snapshot changed")
+	}
+
+	// An update that returns nil empties the set.
+	s.Update(func(*PersistentSet) *PersistentSet { return nil })
+	if !s.Empty() || s.Snapshot() == nil {
+		t.Error("not empty after nil update")
+	}
+	s.Add(3)
+	if !s.Contains(3) || s.Size() != 1 {
+		t.Error("Add after nil update failed")
+	}
+}
+
+func TestConcurrentSparseSetHash(t *testing.T) {
+	// Hash caches hashes in the nodes of a snapshot, which writers copy to
+	// derive new versions.
+	const n = 2000
+	var s ConcurrentSparseSet
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := uint64(0); i < n; i++ {
+			s.Add(i * 1000)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for s.Size() < n {
+			p := s.Snapshot()
+			if got, want := p.Hash(), p.SparseSet().Hash(); got != want {
+				t.Errorf("snapshot hash %#x, hash of its copy %#x", got, want)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+
+	// The same holds for a PersistentSet shared between goroutines.
+	p := s.Snapshot()
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		q := p
+		for i := uint64(0); i < n; i++ {
+			q = q.Add(i*1000 + 1)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for range 100 {
+			p.Hash()
+		}
+	}()
+	wg.Wait()
+}
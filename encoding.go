@@ -0,0 +1,274 @@
+package bit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The binary encoding of a SparseSet is a version byte, followed by
+// the shift of the root node, followed by the prefix of the set as a
+// little-endian 64-bit word, followed by the tree. The shift byte is zero
+// for an empty set, and then nothing follows.
+//
+// A node is encoded as its bitset, followed (for nodes whose subnodes are not
+// leaves) by the Set256 of the indices of its full subnodes, followed by the
+// encodings of its other subnodes in order. A leaf is encoded as its Set256.
+// A Set256 is encoded as four little-endian 64-bit words, lowest elements
+// first.
+//
+// Version 1 of the encoding had no full subnodes, and did not write their
+// Set256. Versions 1 and 2 had no prefix; the root was always at shift 56.
+// ReadFrom accepts all three versions.
+//
+// All the integers in the encoding have fixed sizes, so a set's encoding
+// can be written and read incrementally.
+
+const sparseEncodingVersion = 3
+
+// errCorrupt is returned when decoding invalid data.
+var errCorrupt = errors.New("bit: corrupt SparseSet encoding")
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (s *SparseSet) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := s.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+// If data is not a valid encoding, UnmarshalBinary returns an error and
+// leaves s unchanged.
+func (s *SparseSet) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	var t SparseSet
+	if _, err := t.ReadFrom(r); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if r.Len() > 0 {
+		return fmt.Errorf("%w: %d bytes of extra data", errCorrupt, r.Len())
+	}
+	*s = t
+	return nil
+}
+
+// WriteTo writes the binary encoding of s to w. It implements io.WriterTo.
+func (s *SparseSet) WriteTo(w io.Writer) (int64, error) {
+	ew := &encWriter{w: w}
+	var shift uint8
+	if s.root != nil {
+		shift = uint8(s.root.shift)
+	}
+	ew.write([]byte{sparseEncodingVersion, shift})
+	if s.root != nil {
+		binary.LittleEndian.PutUint64(ew.buf[:8], s.prefix)
+		ew.write(ew.buf[:8])
+		ew.writeNode(s.root)
+	}
+	return ew.n, ew.err
+}
+
+// encWriter remembers the first error, so callers need only check at the end.
+type encWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+	buf [32]byte
+}
+
+func (ew *encWriter) write(b []byte) {
+	if ew.err != nil {
+		return
+	}
+	n, err := ew.w.Write(b)
+	ew.n += int64(n)
+	ew.err = err
+}
+
+func (ew *encWriter) writeSet256(s *Set256) {
+	for i, t := range s.sets {
+		binary.LittleEndian.PutUint64(ew.buf[i*8:], uint64(t))
+	}
+	ew.write(ew.buf[:])
+}
+
+func (ew *encWriter) writeNode(n *node) {
+	ew.writeSet256(&n.bitset)
+	if n.shift > 8 {
+		var fulls Set256
+		for _, sn := range n.subnodes {
+			if _, ok := sn.sub.(full); ok {
+				fulls.Add(sn.index)
+			}
+		}
+		ew.writeSet256(&fulls)
+	}
+	for _, sn := range n.subnodes {
+		ew.writeSub(sn.sub)
+	}
+}
+
+// writeSub writes the encoding of a subnode. A full has none; its parent
+// records it.
+func (ew *encWriter) writeSub(sub subber) {
+	switch sub := sub.(type) {
+	case *Set256:
+		ew.writeSet256(sub)
+	case *node:
+		ew.writeNode(sub)
+	case *chain:
+		ew.writeChain(sub)
+	}
+}
+
+// writeChain writes c as the nodes it replaces, each with a single subnode.
+// ReadFrom compresses them into a chain again.
+func (ew *encWriter) writeChain(c *chain) {
+	_, isFull := c.sub.(full)
+	for shift := c.shift; shift >= level(c.sub); shift -= 8 {
+		var bitset, fulls Set256
+		bitset.Add(uint8(c.prefix >> shift))
+		ew.writeSet256(&bitset)
+		if shift > 8 {
+			if isFull && shift == level(c.sub) {
+				fulls = bitset
+			}
+			ew.writeSet256(&fulls)
+		}
+	}
+	ew.writeSub(c.sub)
+}
+
+// ReadFrom replaces the contents of s with a set read from r in the format
+// written by WriteTo. It implements io.ReaderFrom.
+// ReadFrom reads exactly the bytes of one encoded set, so several sets may
+// be read from the same stream. If r is at end of file, ReadFrom returns
+// io.EOF. If the data is invalid, ReadFrom returns an error and leaves s
+// unchanged.
+func (s *SparseSet) ReadFrom(r io.Reader) (int64, error) {
+	dr := &decReader{r: r}
+	var hdr [2]byte
+	if err := dr.read(hdr[:]); err != nil {
+		if dr.n == 0 {
+			return 0, io.EOF
+		}
+		return dr.n, err
+	}
+	version := hdr[0]
+	if version < 1 || version > sparseEncodingVersion {
+		return dr.n, fmt.Errorf("bit: unknown SparseSet encoding version %d", hdr[0])
+	}
+	shift := uint(hdr[1])
+	if shift == 0 {
+		*s = SparseSet{}
+		return dr.n, nil
+	}
+	if shift%8 != 0 || shift > 64-8 {
+		return dr.n, fmt.Errorf("%w: bad root shift %d", errCorrupt, shift)
+	}
+	var prefix uint64
+	if version >= 3 {
+		if err := dr.read(dr.buf[:8]); err != nil {
+			return dr.n, err
+		}
+		prefix = binary.LittleEndian.Uint64(dr.buf[:8])
+		if prefix&(uint64(1)<<(shift+8)-1) != 0 {
+			return dr.n, fmt.Errorf("%w: bad prefix %#x", errCorrupt, prefix)
+		}
+	}
+	dr.version = version
+	root, err := dr.readNode(shift)
+	if err != nil {
+		return dr.n, err
+	}
+	*s = SparseSet{root: root, prefix: prefix}
+	// Older encodings always had seven levels.
+	s.trimRoot()
+	return dr.n, nil
+}
+
+type decReader struct {
+	r       io.Reader
+	version byte
+	n       int64
+	buf     [32]byte
+}
+
+func (dr *decReader) read(b []byte) error {
+	n, err := io.ReadFull(dr.r, b)
+	dr.n += int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// readSet256 reads a Set256, which must not be empty.
+func (dr *decReader) readSet256(s *Set256) error {
+	if err := dr.readMaybeEmptySet256(s); err != nil {
+		return err
+	}
+	if s.Empty() {
+		return fmt.Errorf("%w: empty bitset", errCorrupt)
+	}
+	return nil
+}
+
+func (dr *decReader) readMaybeEmptySet256(s *Set256) error {
+	if err := dr.read(dr.buf[:]); err != nil {
+		return err
+	}
+	for i := range s.sets {
+		s.sets[i] = Set64(binary.LittleEndian.Uint64(dr.buf[i*8:]))
+	}
+	return nil
+}
+
+func (dr *decReader) readNode(shift uint) (*node, error) {
+	n := &node{shift: shift}
+	if err := dr.readSet256(&n.bitset); err != nil {
+		return nil, err
+	}
+	var fulls Set256
+	if dr.version > 1 && shift > 8 {
+		if err := dr.readMaybeEmptySet256(&fulls); err != nil {
+			return nil, err
+		}
+		extra := fulls
+		extra.DifferenceWith(&n.bitset)
+		if !extra.Empty() {
+			return nil, fmt.Errorf("%w: full subnode not in bitset", errCorrupt)
+		}
+	}
+	var indices [256]uint8
+	size := n.bitset.Elements(indices[:], 0)
+	n.subnodes = make([]subnode, size)
+	for i, index := range indices[:size] {
+		var sub subber
+		if fulls.Contains(index) {
+			sub = full{shift: shift - 8}
+		} else if shift == 8 {
+			leaf := &Set256{}
+			if err := dr.readSet256(leaf); err != nil {
+				return nil, err
+			}
+			sub = leaf
+		} else {
+			c, err := dr.readNode(shift - 8)
+			if err != nil {
+				return nil, err
+			}
+			sub = compact(c)
+		}
+		n.subnodes[i] = subnode{index: index, sub: sub}
+		n.count = addCounts(n.count, sub.size())
+	}
+	return n, nil
+}
@@ -0,0 +1,146 @@
+package bit
+
+import (
+	"bytes"
+	"encoding"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+var (
+	_ encoding.BinaryMarshaler   = (*SparseSet)(nil)
+	_ encoding.BinaryUnmarshaler = (*SparseSet)(nil)
+	_ io.WriterTo                = (*SparseSet)(nil)
+	_ io.ReaderFrom              = (*SparseSet)(nil)
+)
+
+func randSparseSet(n int) *SparseSet {
+	s := &SparseSet{}
+	for i := 0; i < n; i++ {
+		if i%2 == 0 {
+			s.Add(uint64(rand.Intn(5000)))
+		} else {
+			s.Add(randUint64())
+		}
+	}
+	return s
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	for _, s := range []*SparseSet{
+		{},
+		NewSparseSet(0),
+		NewSparseSet(1<<64 - 1),
+		NewSparseSet(9, 99, 1e8),
+		NewSparseSet(1<<60+3, 1<<60+70000),
+		randSparseSet(1000),
+	} {
+		data, err := s.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got SparseSet
+		if err := got.UnmarshalBinary(data); err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		if !got.Equal(s) || got.Size() != s.Size() {
+			t.Errorf("got %s, want %s", got, s)
+		}
+	}
+}
+
+func TestBinaryStream(t *testing.T) {
+	sets := []*SparseSet{randSparseSet(100), {}, randSparseSet(10)}
+	var buf bytes.Buffer
+	for _, s := range sets {
+		n, err := s.WriteTo(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			t.Fatal("wrote zero bytes")
+		}
+	}
+	for _, want := range sets {
+		var got SparseSet
+		if _, err := got.ReadFrom(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equal(want) {
+			t.Errorf("got %s, want %s", got, want)
+		}
+	}
+	var s SparseSet
+	if _, err := s.ReadFrom(&buf); err != io.EOF {
+		t.Errorf("at end: got %v, want io.EOF", err)
+	}
+}
+
+func TestBinaryCorrupt(t *testing.T) {
+	s := NewSparseSet(9, 99, 1e8, 1e8+300)
+	data, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every proper prefix is an error.
+	for i := 0; i < len(data); i++ {
+		var got SparseSet
+		if err := got.UnmarshalBinary(data[:i]); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("prefix of length %d: got %v, want io.ErrUnexpectedEOF", i, err)
+		}
+	}
+	for _, test := range []struct {
+		name string
+		data []byte
+	}{
+		{"version", append([]byte{99}, data[1:]...)},
+		{"shift", append([]byte{sparseEncodingVersion, 7}, data[2:]...)},
+		{"big shift", append([]byte{sparseEncodingVersion, 64}, data[2:]...)},
+		{"extra", append(data[:len(data):len(data)], 0)},
+		{"empty bitset", append([]byte{sparseEncodingVersion, 56}, make([]byte, 40)...)},
+		{"bad prefix", append([]byte{sparseEncodingVersion, data[1], 1}, data[3:]...)},
+	} {
+		var got SparseSet
+		if err := got.UnmarshalBinary(test.data); err == nil {
+			t.Errorf("%s: got nil error", test.name)
+		}
+	}
+	// Random corruption must not panic.
+	for i := 0; i < 1000; i++ {
+		d := append([]byte(nil), data...)
+		d[rand.Intn(len(d))] ^= byte(1 << uint(rand.Intn(8)))
+		var got SparseSet
+		_ = got.UnmarshalBinary(d)
+	}
+	// A failed unmarshal leaves the set unchanged.
+	got := NewSparseSet(5)
+	if err := got.UnmarshalBinary(data[:10]); err == nil {
+		t.Fatal("got nil error")
+	}
+	if !got.Equal(NewSparseSet(5)) {
+		t.Errorf("set changed to %s", got)
+	}
+}
+
+func TestBinaryVersion1(t *testing.T) {
+	// The set {5} in version 1: seven nodes and a leaf.
+	data := []byte{1, 56}
+	for i := 0; i < 7; i++ {
+		data = append(data, 1)
+		data = append(data, make([]byte, 31)...)
+	}
+	data = append(data, 1<<5)
+	data = append(data, make([]byte, 31)...)
+	var got SparseSet
+	if err := got.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if want := NewSparseSet(5); !got.Equal(want) {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if h := len(got.Stats().Levels); h != 1 {
+		t.Errorf("height %d, want 1", h)
+	}
+}
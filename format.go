@@ -0,0 +1,163 @@
+package bit
+
+import (
+	"fmt"
+	"io"
+	"iter"
+	"strconv"
+)
+
+// The set types implement fmt.Formatter, so that printing a huge set by
+// accident does not print, or allocate memory for, all of its elements.
+//
+//	%v, %s   the elements in braces, like {1, 2, 3}; if there are more than
+//	         formatLimit of them, the rest are elided: {1, 2, 3, ...(+997 more)}
+//	%.Nv     as %v, but elides all but the first N elements
+//	%+v      all the elements
+//	%#v      a Go expression that constructs the set, with all the elements
+//	%x, %X   for Set64, Set256 and Set, the words of the set in hexadecimal,
+//	         lowest elements first
+//
+// Other verbs, and %x and %X for SparseSet, format each element with the verb,
+// inside braces, as with %v. The String methods use %v.
+
+// formatLimit is the number of elements that %v prints by default.
+const formatLimit = 100
+
+// formatElements implements Format for a set with size elements, which els
+// yields in increasing order. The Go syntax for the set is a call to goFunc,
+// with goArgs followed by the elements as arguments.
+func formatElements(f fmt.State, verb rune, size int, els iter.Seq[uint64], goFunc string, goArgs ...string) {
+	var b []byte
+	if verb == 'v' && f.Flag('#') {
+		b = append(b, goFunc...)
+		b = append(b, '(')
+		for i, a := range goArgs {
+			if i > 0 {
+				b = append(b, ", "...)
+			}
+			b = append(b, a...)
+		}
+		sep := len(goArgs) > 0
+		for e := range els {
+			if sep {
+				b = append(b, ", "...)
+			}
+			sep = true
+			b = strconv.AppendUint(b, e, 10)
+		}
+		b = append(b, ')')
+		f.Write(b)
+		return
+	}
+
+	limit := formatLimit
+	if p, ok := f.Precision(); ok {
+		limit = p
+	}
+	if verb == 'v' && f.Flag('+') {
+		limit = size
+	}
+	var directive string
+	switch verb {
+	case 'v', 's', 'd':
+	default:
+		// Format each element with the verb and the '#' flag, if any.
+		directive = "%" + string(verb)
+		if f.Flag('#') {
+			directive = "%#" + string(verb)
+		}
+	}
+	b = append(b, '{')
+	n := 0
+	for e := range els {
+		if n == limit {
+			break
+		}
+		if n > 0 {
+			b = append(b, ", "...)
+		}
+		if directive == "" {
+			b = strconv.AppendUint(b, e, 10)
+		} else {
+			b = fmt.Appendf(b, directive, e)
+		}
+		n++
+	}
+	if n < size {
+		if n > 0 {
+			b = append(b, ", "...)
+		}
+		b = fmt.Appendf(b, "...(+%d more)", size-n)
+	}
+	b = append(b, '}')
+	f.Write(b)
+}
+
+// formatWords formats words, a representation of a dense set, with the
+// directive in f.
+func formatWords(f fmt.State, verb rune, words any) {
+	io.WriteString(f, fmt.Sprintf(fmt.FormatString(f, verb), words))
+}
+
+// Format implements fmt.Formatter.
+func (s Set64) Format(f fmt.State, verb rune) {
+	switch {
+	case verb == 'x' || verb == 'X':
+		formatWords(f, verb, uint64(s))
+	case verb == 'v' && f.Flag('#'):
+		fmt.Fprintf(f, "bit.Set64(%#x)", uint64(s))
+	default:
+		formatElements(f, verb, s.Size(), set64Elements(s), "")
+	}
+}
+
+func set64Elements(s Set64) iter.Seq[uint64] {
+	return func(yield func(uint64) bool) {
+		s.Each(func(e uint8) bool { return yield(uint64(e)) })
+	}
+}
+
+// Format implements fmt.Formatter.
+func (s Set256) Format(f fmt.State, verb rune) {
+	if verb == 'x' || verb == 'X' {
+		formatWords(f, verb, []uint64{uint64(s.sets[0]), uint64(s.sets[1]), uint64(s.sets[2]), uint64(s.sets[3])})
+		return
+	}
+	formatElements(f, verb, s.Size(), func(yield func(uint64) bool) {
+		for e := range s.All() {
+			if !yield(uint64(e)) {
+				return
+			}
+		}
+	}, "bit.NewSet256")
+}
+
+// Format implements fmt.Formatter.
+func (s *Set) Format(f fmt.State, verb rune) {
+	if verb == 'x' || verb == 'X' {
+		words := make([]uint64, len(s.sets))
+		for i, w := range s.sets {
+			words[i] = uint64(w)
+		}
+		formatWords(f, verb, words)
+		return
+	}
+	formatElements(f, verb, s.Size(), func(yield func(uint64) bool) {
+		for e := range s.All() {
+			if !yield(uint64(e)) {
+				return
+			}
+		}
+	}, "bit.NewSetOf", strconv.Itoa(s.Capacity()))
+}
+
+// Format implements fmt.Formatter.
+func (s SparseSet) Format(f fmt.State, verb rune) {
+	formatElements(f, verb, s.Size(), s.All(), "bit.NewSparseSet")
+}
+
+// Format implements fmt.Formatter.
+func (p *PersistentSet) Format(f fmt.State, verb rune) {
+	formatElements(f, verb, p.Size(), p.All(), "bit.NewPersistentSet")
+}
@@ -0,0 +1,81 @@
+package bit
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	big := &SparseSet{}
+	big.AddRange(10, 1e7)
+	s256 := sampleSet256()
+	for _, test := range []struct {
+		format string
+		arg    any
+		want   string
+	}{
+		{"%v", NewSparseSet(), "{}"},
+		{"%v", NewSparseSet(1, 2, 3), "{1, 2, 3}"},
+		{"%s", NewSparseSet(1, 2, 3), "{1, 2, 3}"},
+		{"%d", *NewSparseSet(1, 2, 3), "{1, 2, 3}"},
+		{"%x", NewSparseSet(10, 255), "{a, ff}"},
+		{"%#x", NewSparseSet(10, 255), "{0xa, 0xff}"},
+		{"%.2v", NewSparseSet(1, 2, 3), "{1, 2, ...(+1 more)}"},
+		{"%.0v", NewSparseSet(1, 2, 3), "{...(+3 more)}"},
+		{"%.5v", NewSparseSet(1, 2, 3), "{1, 2, 3}"},
+		{"%+v", NewSparseSet(1, 2, 3), "{1, 2, 3}"},
+		{"%#v", NewSparseSet(1, 2, 3), "bit.NewSparseSet(1, 2, 3)"},
+		{"%#v", NewSparseSet(), "bit.NewSparseSet()"},
+		{"%#v", NewPersistentSet(5), "bit.NewPersistentSet(5)"},
+		{"%.3v", big, "{10, 11, 12, ...(+9999988 more)}"},
+		{"%.1v", big.Persistent(), "{10, ...(+9999990 more)}"},
+
+		{"%v", sampleSet64(), "{3, 17, 63}"},
+		{"%.1v", sampleSet64(), "{3, ...(+2 more)}"},
+		{"%#v", sampleSet64(), "bit.Set64(0x8000000000020008)"},
+		{"%x", sampleSet64(), "8000000000020008"},
+		{"%#X", sampleSet64(), "0X8000000000020008"},
+
+		{"%v", s256, "{3, 17, 63, 70, 192, 200, 201}"},
+		{"%#v", s256, "bit.NewSet256(3, 17, 63, 70, 192, 200, 201)"},
+		{"%x", s256, "[8000000000020008 40 0 301]"},
+
+		{"%v", newSet(128, 3, 100), "{3, 100}"},
+		{"%#v", newSet(128, 3, 100), "bit.NewSetOf(128, 3, 100)"},
+		{"%#v", NewSet(0), "bit.NewSetOf(0)"},
+		{"%x", newSet(128, 3, 100), "[8 1000000000]"},
+	} {
+		if got := fmt.Sprintf(test.format, test.arg); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.format, got, test.want)
+		}
+	}
+}
+
+func TestStringBounded(t *testing.T) {
+	s := &SparseSet{}
+	s.AddRange(0, 1e7)
+	got := s.String()
+	if want := "..." + "(+9999901 more)}"; !strings.HasSuffix(got, want) || len(got) > 1000 {
+		t.Errorf("got %q", got)
+	}
+	if got := fmt.Sprintf("%+v", NewSet256(1, 2)); got != "{1, 2}" {
+		t.Errorf("got %q", got)
+	}
+	var full Set256
+	full.AddRange(0, 255)
+	if got := full.String(); !strings.HasSuffix(got, "99, ...(+156 more)}") {
+		t.Errorf("Set256: got %q", got)
+	}
+	if got := fmt.Sprintf("%+v", &full); strings.Contains(got, "more") {
+		t.Errorf("Set256 %%+v: got %q", got)
+	}
+}
+
+func BenchmarkString(b *testing.B) {
+	s := &SparseSet{}
+	s.AddRange(0, 1e7)
+	for i := 0; i < b.N; i++ {
+		_ = s.String()
+	}
+}
@@ -0,0 +1,109 @@
+package bit
+
+// A full is a subber for a subtree that contains every possible element.
+// It takes the place of a node at the given shift, which would otherwise
+// need 256 subnodes at each level below it. So a long run of consecutive
+// elements is stored in space proportional to the depth of the tree, plus
+// the partial nodes at either end.
+//
+// The methods of node replace a node with a full when it becomes full, and
+// expand a full back into a node before removing elements from it.
+// A full is immutable, so it may be shared.
+type full struct {
+	shift uint
+}
+
+// expand returns a node equivalent to f.
+func (f full) expand() *node {
+	n := &node{
+		shift:    f.shift,
+		count:    f.size(),
+		subnodes: make([]subnode, 256),
+	}
+	for i := range n.subnodes {
+		var sub subber
+		if f.shift == 8 {
+			sub = fullSet256()
+		} else {
+			sub = full{shift: f.shift - 8}
+		}
+		n.bitset.Add(uint8(i))
+		n.subnodes[i] = subnode{index: uint8(i), sub: sub}
+	}
+	return n
+}
+
+func fullSet256() *Set256 {
+	var s Set256
+	for i := range s.sets {
+		s.sets[i] = ^Set64(0)
+	}
+	return &s
+}
+
+// mask selects the bits of an element below the level of f.
+func (f full) mask() uint64 { return 1<<(f.shift+8) - 1 }
+
+func (f full) add(uint64) bool { return false }
+
+func (f full) remove(uint64) (removed, empty bool) {
+	panic("full.remove: must expand first")
+}
+
+func (f full) contains(uint64) bool { return true }
+
+func (f full) rank(e uint64) int { return intCount(e & f.mask()) }
+
+func (f full) nth(k int) uint64 { return uint64(k) }
+
+func (f full) elements(a []uint64, start, high uint64) int {
+	n := 0
+	for e := start & f.mask(); e <= f.mask() && n < len(a); e++ {
+		a[n] = high | e
+		n++
+	}
+	return n
+}
+
+func (f full) addRangeSub(lo, hi uint64) subber { return f }
+
+func (f full) removeRangeSub(lo, hi uint64) subber {
+	return f.expand().removeRangeSub(lo, hi)
+}
+
+func (f full) flipRangeSub(lo, hi uint64) subber {
+	if hi-lo == f.mask() {
+		return nil
+	}
+	return f.expand().flipRangeSub(lo, hi)
+}
+
+func (f full) containsAll(lo, hi uint64) bool { return true }
+
+func (f full) countRange(lo, hi uint64) int { return addCounts(intCount(hi-lo), 1) }
+
+func (f full) size() int { return fullSize(f.shift) }
+
+// A full is stored in the interface value itself, so it takes no memory
+// beyond that of its subnode.
+func (f full) memSize() uint64 { return 0 }
+
+func (f full) equalSub(s subber) bool { return s.size() == f.size() }
+
+func (f full) intersectionSizeSub(s subber) int { return s.size() }
+
+func (f full) subsetSub(s subber) bool { return s.size() == f.size() }
+
+func (f full) intersectsSub(subber) bool { return true }
+
+func (f full) copySub() subber { return f }
+
+func (f full) unionWithSub(subber) {}
+
+func (f full) differenceWithSub(subber) bool {
+	panic("full.differenceWithSub: must expand first")
+}
+
+func (f full) symmetricDifferenceWithSub(subber) bool {
+	panic("full.symmetricDifferenceWithSub: must expand first")
+}
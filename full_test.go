@@ -0,0 +1,382 @@
+package bit
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// rangeSet returns a SparseSet containing [lo, hi).
+func rangeSet(lo, hi uint64) *SparseSet {
+	s := &SparseSet{}
+	for e := lo; e < hi; e++ {
+		s.Add(e)
+	}
+	return s
+}
+
+func TestFullSubtree(t *testing.T) {
+	const lo, hi = 1000, 1000 + 3<<16
+	s := rangeSet(lo, hi)
+	if got, want := s.Size(), hi-lo; got != want {
+		t.Fatalf("Size() = %d, want %d", got, want)
+	}
+	// Two of the nodes at shift 8 must be full.
+	nd := s.root
+	for nd.shift > 16 {
+		nd = nd.subnodes[0].sub.(*node)
+	}
+	nfull := 0
+	for _, sn := range nd.subnodes {
+		if _, ok := sn.sub.(full); ok {
+			nfull++
+		}
+	}
+	if nfull != 2 {
+		t.Errorf("got %d full subtrees, want 2", nfull)
+	}
+	for _, e := range []uint64{lo, lo + 1, 1 << 16, hi - 1} {
+		if !s.Contains(e) {
+			t.Errorf("does not contain %d", e)
+		}
+		if got, want := s.Rank(e), int(e-lo); got != want {
+			t.Errorf("Rank(%d) = %d, want %d", e, got, want)
+		}
+		if got, ok := s.Select(int(e - lo)); !ok || got != e {
+			t.Errorf("Select(%d) = %d, %t, want %d, true", e-lo, got, ok, e)
+		}
+	}
+	if s.Contains(lo-1) || s.Contains(hi) {
+		t.Error("contains element out of range")
+	}
+
+	a := make([]uint64, 5)
+	n := s.Elements(a, 1<<17-2)
+	if want := []uint64{1<<17 - 2, 1<<17 - 1, 1 << 17, 1<<17 + 1, 1<<17 + 2}; !cmp.Equal(a[:n], want) {
+		t.Errorf("Elements: got %v, want %v", a[:n], want)
+	}
+	it := s.Iterator()
+	it.Seek(1<<17 - 2)
+	for _, want := range a {
+		if got, ok := it.Next(); !ok || got != want {
+			t.Fatalf("Next() = %d, %t, want %d, true", got, ok, want)
+		}
+	}
+	if got := len(drain(s.Iterator())); got != hi-lo {
+		t.Errorf("iterator returned %d elements, want %d", got, hi-lo)
+	}
+
+	// Removing from a full subtree expands it.
+	c := rangeSet(lo, hi)
+	c.Remove(1 << 16)
+	if c.Contains(1<<16) || !c.Contains(1<<16+1) || c.Size() != hi-lo-1 {
+		t.Error("bad remove")
+	}
+	c.Add(1 << 16)
+	if !c.Equal(s) {
+		t.Error("not equal after re-adding")
+	}
+}
+
+func TestFullMemSize(t *testing.T) {
+	s := rangeSet(0, 1<<20)
+	var sparse SparseSet
+	for e := uint64(0); e < 1<<20; e += 2 {
+		sparse.Add(e)
+	}
+	if s.MemSize()*100 > sparse.MemSize() {
+		t.Errorf("range takes %d bytes, half range takes %d", s.MemSize(), sparse.MemSize())
+	}
+}
+
+func TestFullSetOps(t *testing.T) {
+	r := rangeSet(0, 1<<17)
+	odd := &SparseSet{}
+	for e := uint64(1); e < 1<<18; e += 2 {
+		odd.Add(e)
+	}
+	var got SparseSet
+	got.Intersect(r, odd)
+	if got.Size() != 1<<16 || !got.Contains(1) || got.Contains(2) || got.Contains(1<<17+1) {
+		t.Error("bad intersection")
+	}
+	got.Intersect(r, rangeSet(1<<16, 1<<18))
+	if !got.Equal(rangeSet(1<<16, 1<<17)) {
+		t.Error("bad intersection of ranges")
+	}
+	got.Union(r, odd)
+	if got.Size() != 1<<17+1<<16 {
+		t.Errorf("union: size %d", got.Size())
+	}
+	got.Union(rangeSet(0, 1<<16), rangeSet(1<<16, 1<<17))
+	if !got.Equal(r) {
+		t.Error("union of ranges not equal")
+	}
+	got.Difference(r, odd)
+	if got.Size() != 1<<16 || got.Contains(1) || !got.Contains(2) {
+		t.Error("bad difference")
+	}
+	got.SymmetricDifference(r, odd)
+	if got.Size() != 1<<16+1<<16 || got.Contains(1) || !got.Contains(2) || !got.Contains(1<<17+1) {
+		t.Error("bad symmetric difference")
+	}
+	got.SymmetricDifference(r, r)
+	if !got.Empty() {
+		t.Error("symmetric difference with self not empty")
+	}
+}
+
+func TestFullEncoding(t *testing.T) {
+	s := rangeSet(100, 100+5<<16)
+	s.Add(1 << 40)
+	data, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got SparseSet
+	if err := got.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(s) {
+		t.Error("binary round trip failed")
+	}
+	data, err = s.MarshalRoaring64()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = SparseSet{}
+	if err := got.UnmarshalRoaring64(data); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(s) {
+		t.Error("Roaring round trip failed")
+	}
+}
+
+func TestSparseRanges(t *testing.T) {
+	type span struct{ lo, hi uint64 }
+	// check compares s against a reference set built from spans.
+	base := []uint64{0, 5, 255, 256, 70000, 1 << 20, 1<<40 + 3, 1<<64 - 1}
+	spans := []span{
+		{0, 0}, {0, 255}, {3, 70000}, {255, 256}, {1 << 16, 1<<17 - 1},
+		{100, 1 << 20}, {1<<40 - 10, 1<<40 + 10}, {1<<64 - 300, 1<<64 - 1}, {7, 6},
+	}
+	for _, sp := range spans {
+		in := func(e uint64) bool { return sp.lo <= e && e <= sp.hi }
+		nbase := 0
+		for _, e := range base {
+			if in(e) {
+				nbase++
+			}
+		}
+		width := 0
+		if sp.lo <= sp.hi {
+			width = int(sp.hi - sp.lo + 1)
+		}
+
+		s := NewSparseSet(base...)
+		if got := s.Count(sp.lo, sp.hi); got != nbase {
+			t.Errorf("%v: Count = %d, want %d", sp, got, nbase)
+		}
+		s.AddRange(sp.lo, sp.hi)
+		if want := len(base) - nbase + width; s.Size() != want {
+			t.Errorf("%v: AddRange: size %d, want %d", sp, s.Size(), want)
+		}
+		if !s.ContainsAll(sp.lo, sp.hi) || s.Count(sp.lo, sp.hi) != width {
+			t.Errorf("%v: AddRange: range not contained", sp)
+		}
+		for _, e := range base {
+			if !s.Contains(e) {
+				t.Errorf("%v: AddRange lost %d", sp, e)
+			}
+		}
+		f := s.Copy()
+		s.RemoveRange(sp.lo, sp.hi)
+		if want := len(base) - nbase; s.Size() != want || s.Count(sp.lo, sp.hi) != 0 {
+			t.Errorf("%v: RemoveRange: size %d, want %d", sp, s.Size(), want)
+		}
+		if sp.lo <= sp.hi && s.ContainsAll(sp.lo, sp.hi) {
+			t.Errorf("%v: RemoveRange: ContainsAll true", sp)
+		}
+		// Flipping the range in a set that contains it is removing it.
+		f.FlipRange(sp.lo, sp.hi)
+		if !f.Equal(s) {
+			t.Errorf("%v: FlipRange differs from RemoveRange", sp)
+		}
+		// Flipping again restores the base elements in the range.
+		f.FlipRange(sp.lo, sp.hi)
+		if want := len(base) - nbase + width; f.Size() != want {
+			t.Errorf("%v: second FlipRange: size %d, want %d", sp, f.Size(), want)
+		}
+		g := NewSparseSet(base...)
+		g.FlipRange(sp.lo, sp.hi)
+		if want := len(base) - 2*nbase + width; g.Size() != want {
+			t.Errorf("%v: FlipRange: size %d, want %d", sp, g.Size(), want)
+		}
+		for _, e := range base {
+			if g.Contains(e) == in(e) {
+				t.Errorf("%v: FlipRange: wrong membership of %d", sp, e)
+			}
+		}
+	}
+}
+
+func TestHugeRange(t *testing.T) {
+	var s SparseSet
+	s.AddRange(1, 1<<60)
+	if s.Size() != 1<<60 || !s.Contains(1<<59) || s.Contains(0) {
+		t.Fatal("bad huge range")
+	}
+	// Each level has a partial node with at most 256 subnodes.
+	if s.MemSize() > 7*256*64 {
+		t.Errorf("MemSize() = %d", s.MemSize())
+	}
+	s.RemoveRange(2, 1<<60-1)
+	if got, want := s.String(), "{1, 1152921504606846976}"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestHugeSymmetricDifference(t *testing.T) {
+	// Full subtrees that are in both sets cancel without being expanded,
+	// so these take little time.
+	var s1, s2 SparseSet
+	s1.AddRange(0, 1<<32-1)
+	s2.AddRange(0, 1<<32-1)
+	s1.SymmetricDifferenceWith(&s2)
+	if !s1.Empty() {
+		t.Errorf("equal ranges: got %.5v", s1)
+	}
+
+	s1.AddRange(0, 1<<56-1)
+	s2.Clear()
+	s2.AddRange(5, 1<<56-1)
+	s1.SymmetricDifferenceWith(&s2)
+	if want := NewSparseSet(0, 1, 2, 3, 4); !s1.Equal(want) {
+		t.Errorf("got %.10v, want %s", s1, want)
+	}
+	// The other way round, so that the full is the argument.
+	s2.SymmetricDifferenceWith(NewSparseSet(0, 1, 2, 3, 4))
+	if s2.Size() != 1<<56 {
+		t.Errorf("got size %d, want %d", s2.Size(), 1<<56)
+	}
+	s2.SymmetricDifferenceWith(rangeSet(1<<16, 1<<16+10))
+	if s2.Size() != 1<<56-10 || s2.Contains(1<<16+3) || !s2.Contains(1<<16+10) {
+		t.Errorf("got %.10v", s2)
+	}
+}
+
+func TestFullCountsSaturate(t *testing.T) {
+	// A set can have more elements than an int can count.
+	var s SparseSet
+	s.AddRange(0, math.MaxUint64)
+	if s.Empty() || s.Size() != math.MaxInt {
+		t.Fatalf("Size() = %d, want %d", s.Size(), math.MaxInt)
+	}
+	if got := s.Count(0, math.MaxUint64); got != math.MaxInt {
+		t.Errorf("Count = %d", got)
+	}
+	if got := s.Count(1<<63, math.MaxUint64); got != math.MaxInt {
+		t.Errorf("Count of top half = %d", got)
+	}
+	if got := s.Count(2, 1<<63-1); got != math.MaxInt-1 {
+		t.Errorf("Count of bottom half = %d", got)
+	}
+	s.Remove(5)
+	if s.Size() != math.MaxInt || s.Contains(5) {
+		t.Errorf("after Remove: Size() = %d", s.Size())
+	}
+	// Removing enough brings the count back into range.
+	s.RemoveRange(0, 1<<63)
+	if want := 1<<63 - 1; s.Size() != want {
+		t.Errorf("after RemoveRange: Size() = %d, want %d", s.Size(), want)
+	}
+	s.Remove(math.MaxUint64)
+	if want := 1<<63 - 2; s.Size() != want {
+		t.Errorf("after Remove: Size() = %d, want %d", s.Size(), want)
+	}
+	s.Add(math.MaxUint64)
+	s.Add(0)
+	if s.Size() != math.MaxInt {
+		t.Errorf("after Add: Size() = %d", s.Size())
+	}
+}
+
+func TestRangesAtTop(t *testing.T) {
+	// More elements than an int can count.
+	var s SparseSet
+	s.AddRange(0, 1<<63)
+	if s.Size() != math.MaxInt {
+		t.Errorf("Size() = %d", s.Size())
+	}
+	if got := s.Rank(math.MaxUint64); got != math.MaxInt {
+		t.Errorf("Rank(max) = %d", got)
+	}
+	if got := s.Rank(1 << 62); got != 1<<62 {
+		t.Errorf("Rank(1<<62) = %d", got)
+	}
+	if got, ok := s.Select(5); !ok || got != 5 {
+		t.Errorf("Select(5) = %d, %t", got, ok)
+	}
+	if got, ok := s.Select(math.MaxInt - 1); !ok || got != math.MaxInt-1 {
+		t.Errorf("Select(MaxInt-1) = %d, %t", got, ok)
+	}
+	if got, want := fmt.Sprintf("%.2v", s), fmt.Sprintf("{0, 1, ...(+%d more)}", math.MaxInt-2); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if got := s.UnionSize(NewSparseSet(math.MaxUint64)); got != math.MaxInt {
+		t.Errorf("UnionSize = %d", got)
+	}
+	if got := s.IntersectionSize(&s); got != math.MaxInt {
+		t.Errorf("IntersectionSize = %d", got)
+	}
+	var half SparseSet
+	half.AddRange(1<<62, 1<<63-1)
+	if got := half.UnionSize(rangeSet(0, 10)); got != 1<<62+10 {
+		t.Errorf("UnionSize = %d", got)
+	}
+	if got := half.UnionSize(&s); got != math.MaxInt {
+		t.Errorf("UnionSize = %d", got)
+	}
+
+	// Ranges that end at the largest element.
+	const top = math.MaxUint64
+	s.Clear()
+	s.AddRange(top-10, top)
+	if s.Size() != 11 || !s.ContainsAll(top-10, top) || s.Contains(top-11) {
+		t.Fatalf("AddRange: got %s", s)
+	}
+	s.RemoveRange(top-3, top)
+	if m, _ := s.Max(); s.Size() != 7 || m != top-4 {
+		t.Errorf("RemoveRange: got %s", s)
+	}
+	s.FlipRange(top-5, top)
+	if got, want := fmt.Sprint(s), fmt.Sprint(NewSparseSet(top-10, top-9, top-8, top-7, top-6, top-3, top-2, top-1, top)); got != want {
+		t.Errorf("FlipRange: got %s, want %s", got, want)
+	}
+	if got := s.Count(top-3, top); got != 4 {
+		t.Errorf("Count = %d", got)
+	}
+	if got := s.Rank(top); got != 8 {
+		t.Errorf("Rank(top) = %d", got)
+	}
+	if got, ok := s.NextAfter(top - 1); !ok || got != top {
+		t.Errorf("NextAfter = %d, %t", got, ok)
+	}
+	if _, ok := s.NextAfter(top); ok {
+		t.Error("NextAfter(top) found an element")
+	}
+	if got, ok := s.Select(8); !ok || got != top {
+		t.Errorf("Select(8) = %d, %t", got, ok)
+	}
+	s.FlipRange(0, top)
+	if s.Size() != math.MaxInt || s.Contains(top) || !s.Contains(top-4) || !s.Contains(0) {
+		t.Errorf("FlipRange of everything: size %d", s.Size())
+	}
+	s.FlipRange(0, top)
+	if s.Size() != 9 {
+		t.Errorf("FlipRange back: got %s", s)
+	}
+}
@@ -0,0 +1,171 @@
+package bit
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// An Element is a type that can be the element type of a BitSet.
+type Element interface {
+	~uint8 | ~uint64 | ~int
+}
+
+// A BitSet is a set of non-negative integers of type T.
+// Each set type implements BitSet for its own element type: *Set64 and
+// *Set256 for uint8, *Set for int, and *SparseSet for uint64. The functions
+// in this file work on any BitSet, and can combine sets of different types
+// with the same element type.
+//
+// To write code once for every set type, write it for BitSet[uint64], and
+// pass it sets wrapped with AsUint64. Then switching between the dense and
+// sparse representations only changes the code that creates the set.
+type BitSet[T Element] interface {
+	Add(T)
+	Remove(T)
+	Contains(T) bool
+	Size() int
+	Empty() bool
+	Clear()
+	// Each calls its argument on each element of the set in increasing order,
+	// until the argument returns false.
+	Each(func(T) bool)
+}
+
+var (
+	_ BitSet[uint8]  = (*Set64)(nil)
+	_ BitSet[uint8]  = (*Set256)(nil)
+	_ BitSet[int]    = (*Set)(nil)
+	_ BitSet[uint64] = (*SparseSet)(nil)
+)
+
+// AsUint64 returns a view of s as a BitSet[uint64]. Changes to the view
+// change s, and vice versa. If s is already a BitSet[uint64], AsUint64
+// returns it.
+//
+// If s has a Capacity method, as the dense sets do, the elements of the view
+// must be less than the capacity; otherwise they must fit in a T. The view's
+// Add method panics if its argument does not, and Contains and Remove treat
+// such arguments as absent.
+func AsUint64[T Element](s BitSet[T]) BitSet[uint64] {
+	if u, ok := s.(BitSet[uint64]); ok {
+		return u
+	}
+	return uint64View[T]{s}
+}
+
+type uint64View[T Element] struct {
+	s BitSet[T]
+}
+
+// element returns e as a T, and reports whether it is a valid element of v.
+func (v uint64View[T]) element(e uint64) (T, bool) {
+	if c, ok := v.s.(interface{ Capacity() int }); ok && e >= uint64(c.Capacity()) {
+		return 0, false
+	}
+	t := T(e)
+	return t, t >= 0 && uint64(t) == e
+}
+
+func (v uint64View[T]) Add(e uint64) {
+	t, ok := v.element(e)
+	if !ok {
+		panic(fmt.Sprintf("bit: element %d out of range for %T", e, v.s))
+	}
+	v.s.Add(t)
+}
+
+func (v uint64View[T]) Remove(e uint64) {
+	if t, ok := v.element(e); ok {
+		v.s.Remove(t)
+	}
+}
+
+func (v uint64View[T]) Contains(e uint64) bool {
+	t, ok := v.element(e)
+	return ok && v.s.Contains(t)
+}
+
+func (v uint64View[T]) Size() int   { return v.s.Size() }
+func (v uint64View[T]) Empty() bool { return v.s.Empty() }
+func (v uint64View[T]) Clear()      { v.s.Clear() }
+
+func (v uint64View[T]) Each(f func(uint64) bool) {
+	v.s.Each(func(e T) bool { return f(uint64(e)) })
+}
+
+// Union adds the elements of each of the srcs to dst.
+// dst must be able to hold them.
+func Union[T Element](dst BitSet[T], srcs ...BitSet[T]) {
+	for _, s := range srcs {
+		if sameSet(s, dst) {
+			continue
+		}
+		s.Each(func(e T) bool {
+			dst.Add(e)
+			return true
+		})
+	}
+}
+
+// Intersect sets dst to the elements that are in all of the srcs.
+// dst may not be one of the srcs. If there are no srcs, dst becomes empty.
+func Intersect[T Element](dst BitSet[T], srcs ...BitSet[T]) {
+	dst.Clear()
+	if len(srcs) == 0 {
+		return
+	}
+	// Iterate over the smallest set, and check the others.
+	// The sets are told apart by index, since a BitSet's dynamic type may
+	// not be comparable.
+	smallest := 0
+	for i, s := range srcs {
+		if s.Size() < srcs[smallest].Size() {
+			smallest = i
+		}
+	}
+	srcs[smallest].Each(func(e T) bool {
+		for i, s := range srcs {
+			if i != smallest && !s.Contains(e) {
+				return true
+			}
+		}
+		dst.Add(e)
+		return true
+	})
+}
+
+// sameSet reports whether a and b are the same set. Unlike a == b, it does
+// not panic if their dynamic type is not comparable; such sets are never the
+// same.
+func sameSet[T Element](a, b BitSet[T]) bool {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	return va.Type() == vb.Type() && va.Comparable() && va.Equal(vb)
+}
+
+// Equal reports whether a and b have the same elements.
+func Equal[T Element](a, b BitSet[T]) bool {
+	return a.Size() == b.Size() && Subset(a, b)
+}
+
+// Subset reports whether every element of a is in b.
+func Subset[T Element](a, b BitSet[T]) bool {
+	if a.Size() > b.Size() {
+		return false
+	}
+	sub := true
+	a.Each(func(e T) bool {
+		sub = b.Contains(e)
+		return sub
+	})
+	return sub
+}
+
+// AppendElements appends the elements of s to dst in increasing order,
+// and returns the result.
+func AppendElements[T Element](dst []T, s BitSet[T]) []T {
+	s.Each(func(e T) bool {
+		dst = append(dst, e)
+		return true
+	})
+	return dst
+}
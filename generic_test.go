@@ -0,0 +1,151 @@
+package bit
+
+import (
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestGenericMixed(t *testing.T) {
+	// The same algorithms work across representations.
+	var s256 Set256
+	var s64 Set64
+	for _, e := range []uint8{1, 5, 9, 200} {
+		s256.Add(e)
+	}
+	for _, e := range []uint8{5, 9, 63} {
+		s64.Add(e)
+	}
+	var got Set256
+	Intersect[uint8](&got, &s256, &s64)
+	if els := AppendElements[uint8](nil, &got); !cmp.Equal(els, []uint8{5, 9}) {
+		t.Errorf("Intersect: got %v", els)
+	}
+	if !Subset[uint8](&got, &s64) || Subset[uint8](&s256, &s64) {
+		t.Error("Subset wrong")
+	}
+	Union[uint8](&got, &s256, &s64)
+	if els := AppendElements[uint8](nil, &got); !cmp.Equal(els, []uint8{1, 5, 9, 63, 200}) {
+		t.Errorf("Union: got %v", els)
+	}
+
+	d := newSet(1000, 3, 500, 999)
+	sp := NewSparseSet(3, 500)
+	var ds BitSet[int] = d
+	if Equal[int](ds, newSet(600, 3)) {
+		t.Error("Equal: want false")
+	}
+	d.Remove(999)
+	if !Equal[int](ds, newSet(640, 3, 500)) {
+		t.Error("Equal: want true")
+	}
+	var u SparseSet
+	Union[uint64](&u, sp, NewSparseSet(1<<60))
+	if els := AppendElements[uint64](nil, &u); !cmp.Equal(els, []uint64{3, 500, 1 << 60}) {
+		t.Errorf("sparse Union: got %v", els)
+	}
+	Intersect[uint64](&u)
+	if !u.Empty() {
+		t.Error("Intersect of no sets is not empty")
+	}
+}
+
+func TestEach(t *testing.T) {
+	// Each stops when f returns false.
+	var got []int
+	newSet(300, 1, 64, 65, 299).Each(func(i int) bool {
+		got = append(got, i)
+		return len(got) < 3
+	})
+	if want := []int{1, 64, 65}; !cmp.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// evens is written once for BitSet[uint64], and works with every set type.
+func evens(s BitSet[uint64], n uint64) {
+	for e := uint64(0); e < n; e += 2 {
+		s.Add(e)
+	}
+}
+
+func TestAsUint64(t *testing.T) {
+	var s64 Set64
+	var s256 Set256
+	sets := []BitSet[uint64]{
+		AsUint64(&s64),
+		AsUint64(&s256),
+		AsUint64(NewSet(100)),
+		AsUint64(&SparseSet{}),
+	}
+	for _, s := range sets {
+		evens(s, 10)
+		s.Remove(4)
+		s.Remove(1 << 40) // too large for the dense sets; ignored
+		if s.Contains(4) || !s.Contains(8) || s.Contains(1<<40) || s.Size() != 4 {
+			t.Errorf("%T: got %v", s, AppendElements(nil, s))
+		}
+	}
+	if s64 != Set64(1<<0|1<<2|1<<6|1<<8) {
+		t.Errorf("Set64 not changed through view: %s", s64)
+	}
+	if _, ok := sets[3].(*SparseSet); !ok {
+		t.Errorf("AsUint64 wrapped a SparseSet: %T", sets[3])
+	}
+
+	// The generic algorithms combine different representations.
+	var u SparseSet
+	Union(&u, sets...)
+	if !Equal(sets[0], &u) || !Equal[uint64](&u, sets[2]) {
+		t.Errorf("Union: got %s", u)
+	}
+	if Subset(AsUint64(NewSetOf(64, 1)), sets[1]) {
+		t.Error("Subset: want false")
+	}
+
+	for _, s := range sets[:3] {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%T: no panic adding 1000", s)
+				}
+			}()
+			s.Add(1000)
+		}()
+	}
+}
+
+// mapSet is a BitSet whose dynamic type is not comparable.
+type mapSet map[int]bool
+
+func (m mapSet) Add(e int)           { m[e] = true }
+func (m mapSet) Remove(e int)        { delete(m, e) }
+func (m mapSet) Contains(e int) bool { return m[e] }
+func (m mapSet) Size() int           { return len(m) }
+func (m mapSet) Empty() bool         { return len(m) == 0 }
+func (m mapSet) Clear()              { clear(m) }
+
+func (m mapSet) Each(f func(int) bool) {
+	for _, e := range slices.Sorted(maps.Keys(m)) {
+		if !f(e) {
+			return
+		}
+	}
+}
+
+func TestGenericUncomparable(t *testing.T) {
+	a := mapSet{1: true, 2: true, 3: true}
+	b := mapSet{2: true, 3: true}
+	got := NewSet(64)
+	Intersect[int](got, a, b, newSet(64, 3, 4))
+	if els := AppendElements[int](nil, got); !cmp.Equal(els, []int{3}) {
+		t.Errorf("Intersect: got %v", els)
+	}
+	u := mapSet{}
+	Union[int](u, a, u, b, newSet(64, 7))
+	if els := AppendElements[int](nil, u); !cmp.Equal(els, []int{1, 2, 3, 7}) {
+		t.Errorf("Union: got %v", els)
+	}
+}
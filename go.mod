@@ -1,6 +1,8 @@
 // Deprecated: use github.com/jba/bitset instead.
 module github.com/jba/bit
 
-go 1.14
+go 1.23
 
 require github.com/google/go-cmp v0.4.0
+
+require golang.org/x/xerrors v0.0.0-20191204190536-9bdfabe68543 // indirect
@@ -0,0 +1,523 @@
+package bit
+
+import (
+	"math"
+	"math/bits"
+)
+
+// The iterators in this file produce the elements of a set in increasing order.
+// Unlike the Elements methods, they do not require the caller to provide a
+// buffer, and they remember their position between calls.
+//
+// The behavior of an iterator is undefined if its set is modified during
+// iteration.
+
+// next returns the smallest element of s that is at least start.
+// The second return value is false if there is no such element.
+func (s Set64) next(start uint8) (uint8, bool) {
+	w := uint64(s) >> start << start
+	if w == 0 {
+		return 0, false
+	}
+	return uint8(bits.TrailingZeros64(w)), true
+}
+
+// next returns the smallest element of s that is at least start.
+// The second return value is false if there is no such element.
+func (s *Set256) next(start uint8) (uint8, bool) {
+	for i := start / 64; i < 4; i++ {
+		var st uint8
+		if i == start/64 {
+			st = start % 64
+		}
+		if e, ok := s.sets[i].next(st); ok {
+			return i*64 + e, true
+		}
+	}
+	return 0, false
+}
+
+// prev returns the largest element of s that is at most end.
+// The second return value is false if there is no such element.
+func (s Set64) prev(end uint8) (uint8, bool) {
+	w := uint64(s) & (2<<end - 1)
+	if w == 0 {
+		return 0, false
+	}
+	return uint8(63 - bits.LeadingZeros64(w)), true
+}
+
+// prev returns the largest element of s that is at most end.
+// The second return value is false if there is no such element.
+func (s *Set256) prev(end uint8) (uint8, bool) {
+	for i := int(end / 64); i >= 0; i-- {
+		st := uint8(63)
+		if i == int(end/64) {
+			st = end % 64
+		}
+		if e, ok := s.sets[i].prev(st); ok {
+			return uint8(i)*64 + e, true
+		}
+	}
+	return 0, false
+}
+
+// next returns the smallest element of s that is at least start.
+// The second return value is false if there is no such element.
+func (s *Set) next(start uint64) (uint64, bool) {
+	for i := start / 64; i < uint64(len(s.sets)); i++ {
+		var st uint8
+		if i == start/64 {
+			st = uint8(start % 64)
+		}
+		if e, ok := s.sets[i].next(st); ok {
+			return i*64 + uint64(e), true
+		}
+	}
+	return 0, false
+}
+
+// prev returns the largest element of s that is at most end.
+// The second return value is false if there is no such element.
+func (s *Set) prev(end uint64) (uint64, bool) {
+	n := uint64(len(s.sets))
+	if n == 0 {
+		return 0, false
+	}
+	if end >= n*64 {
+		end = n*64 - 1
+	}
+	for i := int(end / 64); i >= 0; i-- {
+		st := uint8(63)
+		if i == int(end/64) {
+			st = uint8(end % 64)
+		}
+		if e, ok := s.sets[i].prev(st); ok {
+			return uint64(i)*64 + uint64(e), true
+		}
+	}
+	return 0, false
+}
+
+// firstSub returns the smallest element of sub, whose elements have the
+// high bits high. sub must not be empty.
+func firstSub(sub subber, high uint64) uint64 {
+	switch sub := sub.(type) {
+	case *Set256:
+		e, _ := sub.next(0)
+		return high | uint64(e)
+	case full:
+		return high
+	case *chain:
+		return firstSub(sub.sub, high|sub.prefix)
+	}
+	n := sub.(*node)
+	sn := n.subnodes[0]
+	return firstSub(sn.sub, high|uint64(sn.index)<<n.shift)
+}
+
+// lastSub returns the largest element of sub, whose elements have the
+// high bits high. sub must not be empty.
+func lastSub(sub subber, high uint64) uint64 {
+	switch sub := sub.(type) {
+	case *Set256:
+		e, _ := sub.prev(255)
+		return high | uint64(e)
+	case full:
+		return high | sub.mask()
+	case *chain:
+		return lastSub(sub.sub, high|sub.prefix)
+	}
+	n := sub.(*node)
+	sn := n.subnodes[len(n.subnodes)-1]
+	return lastSub(sn.sub, high|uint64(sn.index)<<n.shift)
+}
+
+// nextSub returns the smallest element of sub that is at least start.
+// The high bits of start must be those of sub's elements.
+// The second return value is false if there is no such element.
+func nextSub(sub subber, start uint64) (uint64, bool) {
+	switch sub := sub.(type) {
+	case *Set256:
+		e, ok := sub.next(uint8(start))
+		return start&^0xff | uint64(e), ok
+	case full:
+		return start, true
+	case *chain:
+		switch p := start & sub.pathMask(); {
+		case p < sub.prefix:
+			return firstSub(sub.sub, sub.base(start)), true
+		case p > sub.prefix:
+			return 0, false
+		}
+		return nextSub(sub.sub, start)
+	}
+	n := sub.(*node)
+	index := uint8(start >> n.shift)
+	p, found := n.bitset.Position(index)
+	if found {
+		if e, ok := nextSub(n.subnodes[p].sub, start); ok {
+			return e, true
+		}
+		p++
+	}
+	if p == len(n.subnodes) {
+		return 0, false
+	}
+	// The subnode at p is the first one whose elements are all greater than start.
+	sn := n.subnodes[p]
+	high := start >> (n.shift + 8) << (n.shift + 8)
+	return firstSub(sn.sub, high|uint64(sn.index)<<n.shift), true
+}
+
+// prevSub returns the largest element of sub that is at most end.
+// The high bits of end must be those of sub's elements.
+// The second return value is false if there is no such element.
+func prevSub(sub subber, end uint64) (uint64, bool) {
+	switch sub := sub.(type) {
+	case *Set256:
+		e, ok := sub.prev(uint8(end))
+		return end&^0xff | uint64(e), ok
+	case full:
+		return end, true
+	case *chain:
+		switch p := end & sub.pathMask(); {
+		case p > sub.prefix:
+			return lastSub(sub.sub, sub.base(end)), true
+		case p < sub.prefix:
+			return 0, false
+		}
+		return prevSub(sub.sub, end)
+	}
+	n := sub.(*node)
+	index := uint8(end >> n.shift)
+	p, found := n.bitset.Position(index)
+	if found {
+		if e, ok := prevSub(n.subnodes[p].sub, end); ok {
+			return e, true
+		}
+	}
+	if p == 0 {
+		return 0, false
+	}
+	// The subnode at p-1 is the last one whose elements are all less than end.
+	sn := n.subnodes[p-1]
+	high := end >> (n.shift + 8) << (n.shift + 8)
+	return lastSub(sn.sub, high|uint64(sn.index)<<n.shift), true
+}
+
+// A Set256Iterator iterates over the elements of a Set256.
+type Set256Iterator struct {
+	s    *Set256
+	next int // the smallest possible next element; 256 when done
+}
+
+// Iterator returns an iterator positioned at the smallest element of s.
+func (s *Set256) Iterator() *Set256Iterator {
+	return &Set256Iterator{s: s}
+}
+
+// Next returns the next element of the set and true, or 0 and false
+// if there are no more elements.
+func (it *Set256Iterator) Next() (uint64, bool) {
+	if it.next >= 256 {
+		return 0, false
+	}
+	e, ok := it.s.next(uint8(it.next))
+	if !ok {
+		it.next = 256
+		return 0, false
+	}
+	it.next = int(e) + 1
+	return uint64(e), true
+}
+
+// Seek positions the iterator so that the next call to Next returns the
+// smallest element that is at least x.
+func (it *Set256Iterator) Seek(x uint64) {
+	if x >= 256 {
+		it.next = 256
+	} else {
+		it.next = int(x)
+	}
+}
+
+// A SetIterator iterates over the elements of a Set.
+type SetIterator struct {
+	s    *Set
+	next uint64 // the smallest possible next element
+}
+
+// Iterator returns an iterator positioned at the smallest element of s.
+func (s *Set) Iterator() *SetIterator {
+	return &SetIterator{s: s}
+}
+
+// Next returns the next element of the set and true, or 0 and false
+// if there are no more elements.
+func (it *SetIterator) Next() (uint64, bool) {
+	e, ok := it.s.next(it.next)
+	if !ok {
+		it.next = uint64(len(it.s.sets)) * 64
+		return 0, false
+	}
+	it.next = e + 1
+	return e, true
+}
+
+// Seek positions the iterator so that the next call to Next returns the
+// smallest element that is at least x.
+func (it *SetIterator) Seek(x uint64) {
+	it.next = x
+}
+
+// A SparseSetIterator iterates over the elements of a SparseSet.
+// It keeps the path from the root to the current leaf on an explicit stack,
+// so each call to Next does a constant amount of work on average.
+type SparseSetIterator struct {
+	s     SparseSet // the root and prefix of the set
+	stack []iterFrame
+	leaf  *Set256 // current leaf, or nil
+	high  uint64  // the high bits of the elements of leaf
+	lpos  int     // the smallest possible next element in leaf
+	// When inRun is true, the iterator is in a full subtree, and the remaining
+	// elements of the subtree are [runNext, runLast].
+	inRun   bool
+	runNext uint64
+	runLast uint64
+}
+
+type iterFrame struct {
+	n    *node
+	pos  int    // index of the next subnode to visit
+	high uint64 // the high bits of the elements of n
+}
+
+// Iterator returns an iterator positioned at the smallest element of s.
+func (s *SparseSet) Iterator() *SparseSetIterator {
+	it := &SparseSetIterator{s: *s}
+	it.Seek(0)
+	return it
+}
+
+// Next returns the next element of the set and true, or 0 and false
+// if there are no more elements.
+func (it *SparseSetIterator) Next() (uint64, bool) {
+	for {
+		if it.inRun {
+			e := it.runNext
+			if e == it.runLast {
+				it.inRun = false
+			} else {
+				it.runNext++
+			}
+			return e, true
+		}
+		if it.leaf != nil {
+			if it.lpos < 256 {
+				if e, ok := it.leaf.next(uint8(it.lpos)); ok {
+					it.lpos = int(e) + 1
+					return it.high | uint64(e), true
+				}
+			}
+			it.leaf = nil
+		}
+		if len(it.stack) == 0 {
+			return 0, false
+		}
+		top := &it.stack[len(it.stack)-1]
+		if top.pos >= len(top.n.subnodes) {
+			it.stack = it.stack[:len(it.stack)-1]
+			continue
+		}
+		sn := top.n.subnodes[top.pos]
+		top.pos++
+		it.push(sn.sub, top.high|uint64(sn.index)<<top.n.shift, 0)
+	}
+}
+
+// push makes sub the deepest element of the iterator's path, starting at pos.
+func (it *SparseSetIterator) push(sub subber, high uint64, pos int) {
+	switch sub := sub.(type) {
+	case *Set256:
+		it.leaf = sub
+		it.high = high
+		it.lpos = pos
+	case full:
+		it.inRun = true
+		it.runNext = high
+		it.runLast = high | sub.mask()
+	case *chain:
+		it.push(sub.sub, high|sub.prefix, pos)
+	default:
+		it.stack = append(it.stack, iterFrame{n: sub.(*node), pos: pos, high: high})
+	}
+}
+
+// Seek positions the iterator so that the next call to Next returns the
+// smallest element that is at least x. It takes time proportional to the
+// depth of the tree.
+func (it *SparseSetIterator) Seek(x uint64) {
+	it.stack = it.stack[:0]
+	it.leaf = nil
+	it.inRun = false
+	if it.s.root == nil || x > it.s.rootMax() {
+		return
+	}
+	x = max(x, it.s.prefix)
+	high := it.s.prefix
+	n := it.s.root
+	for {
+		index := uint8(x >> n.shift)
+		p, found := n.bitset.Position(index)
+		if !found {
+			// All remaining subnodes hold elements greater than x.
+			it.stack = append(it.stack, iterFrame{n: n, pos: p, high: high})
+			return
+		}
+		it.stack = append(it.stack, iterFrame{n: n, pos: p + 1, high: high})
+		sub := n.subnodes[p].sub
+		high |= uint64(index) << n.shift
+		if c, ok := sub.(*chain); ok {
+			switch p := x & c.pathMask(); {
+			case p < c.prefix:
+				// All the elements of c are greater than x.
+				it.push(c, high, 0)
+				return
+			case p > c.prefix:
+				// All the elements of c are less than x.
+				return
+			}
+			high |= c.prefix
+			sub = c.sub
+		}
+		switch sub := sub.(type) {
+		case *Set256:
+			it.push(sub, high, int(uint8(x)))
+			return
+		case full:
+			it.push(sub, high, 0)
+			it.runNext = high | x&sub.mask()
+			return
+		}
+		n = sub.(*node)
+	}
+}
+
+// The reverse iterators produce the elements of a set in decreasing order.
+
+// reverseIterator holds the state common to the reverse iterators.
+type reverseIterator struct {
+	next uint64 // the largest possible next element
+	done bool
+}
+
+// advance moves the iterator past e, the largest element that is at most
+// it.next, and returns its arguments. If ok is false, there is no such element.
+func (it *reverseIterator) advance(e uint64, ok bool) (uint64, bool) {
+	if !ok || e == 0 {
+		it.done = true
+	} else {
+		it.next = e - 1
+	}
+	return e, ok
+}
+
+// Seek positions the iterator so that the next call to Next returns the
+// largest element that is at most x.
+func (it *reverseIterator) Seek(x uint64) {
+	it.next = x
+	it.done = false
+}
+
+// A Set64ReverseIterator iterates over the elements of a Set64 in
+// decreasing order.
+type Set64ReverseIterator struct {
+	reverseIterator
+	s Set64
+}
+
+// ReverseIterator returns an iterator positioned at the largest element of s.
+// The iterator has its own copy of s, so later changes to s do not affect it.
+func (s Set64) ReverseIterator() *Set64ReverseIterator {
+	return &Set64ReverseIterator{reverseIterator{next: 63}, s}
+}
+
+// Next returns the next element of the set and true, or 0 and false
+// if there are no more elements.
+func (it *Set64ReverseIterator) Next() (uint64, bool) {
+	if it.done {
+		return 0, false
+	}
+	e, ok := it.s.prev(uint8(min(it.next, 63)))
+	return it.advance(uint64(e), ok)
+}
+
+// A Set256ReverseIterator iterates over the elements of a Set256 in
+// decreasing order.
+type Set256ReverseIterator struct {
+	reverseIterator
+	s *Set256
+}
+
+// ReverseIterator returns an iterator positioned at the largest element of s.
+func (s *Set256) ReverseIterator() *Set256ReverseIterator {
+	return &Set256ReverseIterator{reverseIterator{next: 255}, s}
+}
+
+// Next returns the next element of the set and true, or 0 and false
+// if there are no more elements.
+func (it *Set256ReverseIterator) Next() (uint64, bool) {
+	if it.done {
+		return 0, false
+	}
+	end := uint8(255)
+	if it.next < 255 {
+		end = uint8(it.next)
+	}
+	e, ok := it.s.prev(end)
+	return it.advance(uint64(e), ok)
+}
+
+// A SetReverseIterator iterates over the elements of a Set in decreasing
+// order.
+type SetReverseIterator struct {
+	reverseIterator
+	s *Set
+}
+
+// ReverseIterator returns an iterator positioned at the largest element of s.
+func (s *Set) ReverseIterator() *SetReverseIterator {
+	return &SetReverseIterator{reverseIterator{next: math.MaxUint64}, s}
+}
+
+// Next returns the next element of the set and true, or 0 and false
+// if there are no more elements.
+func (it *SetReverseIterator) Next() (uint64, bool) {
+	if it.done {
+		return 0, false
+	}
+	return it.advance(it.s.prev(it.next))
+}
+
+// A SparseSetReverseIterator iterates over the elements of a SparseSet in
+// decreasing order. Each call to Next takes time proportional to the depth
+// of the tree.
+type SparseSetReverseIterator struct {
+	reverseIterator
+	s SparseSet // the root and prefix of the set
+}
+
+// ReverseIterator returns an iterator positioned at the largest element of s.
+func (s *SparseSet) ReverseIterator() *SparseSetReverseIterator {
+	return &SparseSetReverseIterator{reverseIterator{next: math.MaxUint64}, *s}
+}
+
+// Next returns the next element of the set and true, or 0 and false
+// if there are no more elements.
+func (it *SparseSetReverseIterator) Next() (uint64, bool) {
+	if it.done || it.s.root == nil || it.next < it.s.prefix {
+		return 0, false
+	}
+	return it.advance(prevSub(it.s.root, min(it.next, it.s.rootMax())))
+}
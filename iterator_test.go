@@ -0,0 +1,262 @@
+package bit
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+type iterator interface {
+	Next() (uint64, bool)
+	Seek(uint64)
+}
+
+func drain(it iterator) []uint64 {
+	var els []uint64
+	for {
+		e, ok := it.Next()
+		if !ok {
+			return els
+		}
+		els = append(els, e)
+	}
+}
+
+func TestSet256Iterator(t *testing.T) {
+	s := sampleSet256()
+	want := naiveElementsUint64(&s)
+	it := s.Iterator()
+	if got := drain(it); !cmp.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, test := range []struct {
+		seek uint64
+		want []uint64
+	}{
+		{0, want},
+		{64, []uint64{70, 192, 200, 201}},
+		{200, []uint64{200, 201}},
+		{202, nil},
+		{1000, nil},
+	} {
+		it.Seek(test.seek)
+		if got := drain(it); !cmp.Equal(got, test.want) {
+			t.Errorf("Seek(%d): got %v, want %v", test.seek, got, test.want)
+		}
+	}
+}
+
+func TestSetIterator(t *testing.T) {
+	s := newSet(300, 0, 63, 64, 200, 299)
+	it := s.Iterator()
+	if got, want := drain(it), []uint64{0, 63, 64, 200, 299}; !cmp.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	it.Seek(64)
+	if got, want := drain(it), []uint64{64, 200, 299}; !cmp.Equal(got, want) {
+		t.Errorf("after Seek: got %v, want %v", got, want)
+	}
+	it.Seek(1e6)
+	if got := drain(it); got != nil {
+		t.Errorf("got %v, want nil", got)
+	}
+}
+
+func TestSparseSetIterator(t *testing.T) {
+	var empty SparseSet
+	if got := drain(empty.Iterator()); got != nil {
+		t.Errorf("empty: got %v", got)
+	}
+
+	var s SparseSet
+	nums := make([]uint64, 1000)
+	for i := range nums {
+		nums[i] = randUint64()
+		if i%2 == 0 {
+			nums[i] = uint64(rand.Intn(3000))
+		}
+		s.Add(nums[i])
+	}
+	sort.Sort(uslice(nums))
+	want := make([]uint64, s.Size())
+	s.Elements(want, 0)
+	it := s.Iterator()
+	if got := drain(it); !cmp.Equal(got, want) {
+		t.Fatal("iterator and Elements differ")
+	}
+	for i := 0; i < 100; i++ {
+		x := nums[rand.Intn(len(nums))] + uint64(rand.Intn(3)) - 1
+		a := make([]uint64, len(want))
+		n := s.Elements(a, x)
+		it.Seek(x)
+		if got := drain(it); !cmp.Equal(got, a[:n], cmpopts.EquateEmpty()) {
+			t.Fatalf("Seek(%d): iterator and Elements differ", x)
+		}
+	}
+}
+
+// naiveNeighbors returns the elements of sorted that come just before and
+// just after x.
+func naiveNeighbors(sorted []uint64, x uint64) (prev uint64, hasPrev bool, next uint64, hasNext bool) {
+	i := sort.Search(len(sorted), func(i int) bool { return sorted[i] >= x })
+	if i > 0 {
+		prev, hasPrev = sorted[i-1], true
+	}
+	if i < len(sorted) && sorted[i] == x {
+		i++
+	}
+	if i < len(sorted) {
+		next, hasNext = sorted[i], true
+	}
+	return prev, hasPrev, next, hasNext
+}
+
+func TestSparseSetNeighbors(t *testing.T) {
+	var empty SparseSet
+	if _, ok := empty.Min(); ok {
+		t.Error("Min of empty set")
+	}
+	if _, ok := empty.PrevBefore(100); ok {
+		t.Error("PrevBefore in empty set")
+	}
+	s := randSparseSet(500)
+	for i := 0; i < 500; i++ {
+		s.Add(uint64(rand.Intn(5000)))
+	}
+	s.AddRange(1<<30, 1<<30+70000) // full subtrees
+	s.Add(0)
+	s.Add(math.MaxUint64)
+	want := make([]uint64, s.Size())
+	s.Elements(want, 0)
+	if m, _ := s.Min(); m != want[0] {
+		t.Errorf("Min = %d, want %d", m, want[0])
+	}
+	if m, _ := s.Max(); m != want[len(want)-1] {
+		t.Errorf("Max = %d, want %d", m, want[len(want)-1])
+	}
+	xs := []uint64{0, 1, math.MaxUint64, math.MaxUint64 - 1, 1<<30 - 1, 1 << 30, 1<<30 + 70000, 1<<30 + 70001}
+	for i := 0; i < 1000; i++ {
+		xs = append(xs, want[rand.Intn(len(want))]+uint64(rand.Intn(3))-1, uint64(rand.Intn(6000)))
+	}
+	for _, x := range xs {
+		wp, wpok, wn, wnok := naiveNeighbors(want, x)
+		if p, ok := s.PrevBefore(x); p != wp || ok != wpok {
+			t.Fatalf("PrevBefore(%d) = %d, %t, want %d, %t", x, p, ok, wp, wpok)
+		}
+		if n, ok := s.NextAfter(x); n != wn || ok != wnok {
+			t.Fatalf("NextAfter(%d) = %d, %t, want %d, %t", x, n, ok, wn, wnok)
+		}
+	}
+
+	rev := make([]uint64, len(want))
+	for i, e := range want {
+		rev[len(want)-1-i] = e
+	}
+	it := s.ReverseIterator()
+	if got := drain(it); !cmp.Equal(got, rev) {
+		t.Error("ReverseIterator and Elements differ")
+	}
+	it.Seek(1<<30 + 5)
+	if got := drain(it); !cmp.Equal(got, rev[len(rev)-len(got):]) || got[0] != 1<<30+5 {
+		t.Errorf("after Seek: got %v...", got[:3])
+	}
+	if got := drain(empty.ReverseIterator()); got != nil {
+		t.Errorf("empty: got %v", got)
+	}
+}
+
+func TestDenseNeighbors(t *testing.T) {
+	els := []int{0, 5, 63, 64, 130, 255}
+	s := newSet(300, els...)
+	var s256 Set256
+	var s64 Set64
+	var want []uint64
+	for _, e := range els {
+		s256.Add(uint8(e))
+		if e < 64 {
+			s64.Add(uint8(e))
+		}
+		want = append(want, uint64(e))
+	}
+	for x := -1; x <= 300; x++ {
+		wp, wpok, wn, wnok := naiveNeighbors(want, uint64(x))
+		if x < 0 {
+			wp, wpok, wn, wnok = 0, false, 0, true
+		}
+		if p, ok := s.PrevBefore(x); uint64(p) != wp || ok != wpok {
+			t.Errorf("Set.PrevBefore(%d) = %d, %t", x, p, ok)
+		}
+		if n, ok := s.NextAfter(x); uint64(n) != wn || ok != wnok {
+			t.Errorf("Set.NextAfter(%d) = %d, %t", x, n, ok)
+		}
+		if x < 0 || x > 255 {
+			continue
+		}
+		if p, ok := s256.PrevBefore(uint8(x)); uint64(p) != wp || ok != wpok {
+			t.Errorf("Set256.PrevBefore(%d) = %d, %t", x, p, ok)
+		}
+		if n, ok := s256.NextAfter(uint8(x)); uint64(n) != wn || ok != wnok {
+			t.Errorf("Set256.NextAfter(%d) = %d, %t", x, n, ok)
+		}
+		if x > 63 {
+			continue
+		}
+		wp, wpok, wn, wnok = naiveNeighbors(want[:3], uint64(x))
+		if p, ok := s64.PrevBefore(uint8(x)); uint64(p) != wp || ok != wpok {
+			t.Errorf("Set64.PrevBefore(%d) = %d, %t", x, p, ok)
+		}
+		if n, ok := s64.NextAfter(uint8(x)); uint64(n) != wn || ok != wnok {
+			t.Errorf("Set64.NextAfter(%d) = %d, %t", x, n, ok)
+		}
+	}
+	if m, _ := s.Max(); m != 255 {
+		t.Errorf("Set.Max = %d", m)
+	}
+	if m, _ := s256.Min(); m != 0 {
+		t.Errorf("Set256.Min = %d", m)
+	}
+	if m, _ := s64.Max(); m != 63 {
+		t.Errorf("Set64.Max = %d", m)
+	}
+	if _, ok := NewSet(100).Max(); ok {
+		t.Error("Max of empty Set")
+	}
+
+	rev := []uint64{255, 130, 64, 63, 5, 0}
+	for _, it := range []iterator{s.ReverseIterator(), s256.ReverseIterator()} {
+		if got := drain(it); !cmp.Equal(got, rev) {
+			t.Errorf("%T: got %v, want %v", it, got, rev)
+		}
+		it.Seek(129)
+		if got := drain(it); !cmp.Equal(got, rev[2:]) {
+			t.Errorf("%T after Seek: got %v, want %v", it, got, rev[2:])
+		}
+	}
+
+	it := s64.ReverseIterator()
+	if got, want := drain(it), rev[3:]; !cmp.Equal(got, want) {
+		t.Errorf("Set64: got %v, want %v", got, want)
+	}
+	for _, test := range []struct {
+		seek uint64
+		want []uint64
+	}{
+		{1000, rev[3:]},
+		{62, rev[4:]},
+		{5, rev[4:]},
+		{4, rev[5:]},
+		{0, rev[5:]},
+	} {
+		it.Seek(test.seek)
+		if got := drain(it); !cmp.Equal(got, test.want) {
+			t.Errorf("Set64 after Seek(%d): got %v, want %v", test.seek, got, test.want)
+		}
+	}
+	if got := drain(Set64(0).ReverseIterator()); got != nil {
+		t.Errorf("empty Set64: got %v", got)
+	}
+}
@@ -0,0 +1,235 @@
+package bit
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"strconv"
+)
+
+// A JSONFormat selects how a set is encoded as JSON.
+// UnmarshalJSON accepts every format, whatever the type of the set.
+type JSONFormat int
+
+const (
+	// JSONElements encodes a set as an array of its elements in increasing
+	// order: [1,2,3,7]. It is the format of the MarshalJSON methods.
+	JSONElements JSONFormat = iota
+	// JSONRanges encodes a set as an array of [lo,hi] pairs, one for each
+	// run of consecutive elements: [[1,3],[7,7]].
+	JSONRanges
+	// JSONBinary encodes a set as a string holding the base64 encoding of
+	// the binary encoding of a SparseSet with the same elements. See
+	// SparseSet.MarshalBinary.
+	JSONBinary
+)
+
+// A JSONSet is a set that JSON can encode in any JSONFormat.
+// It is implemented by *Set64, *Set256, *Set and *SparseSet.
+type JSONSet interface {
+	runs() iter.Seq2[uint64, uint64]
+	// setFrom replaces the contents of the set with the elements of s,
+	// or returns an error if the set cannot hold them.
+	setFrom(s *SparseSet) error
+}
+
+// JSON encodes Set in the given format. For example,
+//
+//	json.Marshal(bit.JSON{Set: s, Format: bit.JSONRanges})
+//
+// JSON can also be a field of a struct. UnmarshalJSON decodes any format into
+// Set, which must not be nil, and ignores Format.
+type JSON struct {
+	Set    JSONSet
+	Format JSONFormat
+}
+
+// MarshalJSON implements json.Marshaler.
+func (j JSON) MarshalJSON() ([]byte, error) {
+	if j.Set == nil {
+		return []byte("null"), nil
+	}
+	switch j.Format {
+	case JSONElements:
+		b := []byte{'['}
+		for lo, hi := range mergeRuns(j.Set.runs()) {
+			for e := lo; ; e++ {
+				if len(b) > 1 {
+					b = append(b, ',')
+				}
+				b = strconv.AppendUint(b, e, 10)
+				if e == hi {
+					break
+				}
+			}
+		}
+		return append(b, ']'), nil
+	case JSONRanges:
+		b := []byte{'['}
+		for lo, hi := range mergeRuns(j.Set.runs()) {
+			if len(b) > 1 {
+				b = append(b, ',')
+			}
+			b = append(b, '[')
+			b = strconv.AppendUint(b, lo, 10)
+			b = append(b, ',')
+			b = strconv.AppendUint(b, hi, 10)
+			b = append(b, ']')
+		}
+		return append(b, ']'), nil
+	case JSONBinary:
+		s, ok := j.Set.(*SparseSet)
+		if !ok {
+			s = &SparseSet{}
+			for lo, hi := range j.Set.runs() {
+				s.AddRange(lo, hi)
+			}
+		}
+		data, err := s.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		b := []byte{'"'}
+		b = base64.StdEncoding.AppendEncode(b, data)
+		return append(b, '"'), nil
+	default:
+		return nil, fmt.Errorf("bit: unknown JSONFormat %d", j.Format)
+	}
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+// If data is not valid, UnmarshalJSON returns an error and leaves j.Set
+// unchanged. Like the decoders of the json package, it ignores null.
+func (j *JSON) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	var t SparseSet
+	if len(data) > 0 && data[0] == '"' {
+		var b []byte // encoding/json decodes a string into a []byte as base64
+		if err := json.Unmarshal(data, &b); err != nil {
+			return err
+		}
+		if err := t.UnmarshalBinary(b); err != nil {
+			return err
+		}
+		return j.Set.setFrom(&t)
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return fmt.Errorf("bit: JSON set must be an array or a string: %w", err)
+	}
+	for _, item := range items {
+		if len(item) > 0 && item[0] == '[' {
+			var r []uint64
+			if err := json.Unmarshal(item, &r); err != nil {
+				return err
+			}
+			if len(r) != 2 || r[0] > r[1] {
+				return fmt.Errorf("bit: bad JSON set range %s", item)
+			}
+			t.AddRange(r[0], r[1])
+		} else {
+			var e uint64
+			if err := json.Unmarshal(item, &e); err != nil {
+				return err
+			}
+			t.Add(e)
+		}
+	}
+	return j.Set.setFrom(&t)
+}
+
+// MarshalJSON implements json.Marshaler, encoding s as an array of its
+// elements. Use JSON to choose another format.
+func (s Set64) MarshalJSON() ([]byte, error) { return JSON{Set: &s}.MarshalJSON() }
+
+// UnmarshalJSON implements json.Unmarshaler. It accepts every JSONFormat.
+func (s *Set64) UnmarshalJSON(data []byte) error { return (&JSON{Set: s}).UnmarshalJSON(data) }
+
+func (s *Set64) setFrom(t *SparseSet) error {
+	if m, ok := t.Max(); ok && m >= 64 {
+		return fmt.Errorf("bit: element %d out of range for Set64", m)
+	}
+	var u Set64
+	t.Each(func(e uint64) bool {
+		u.Add(uint8(e))
+		return true
+	})
+	*s = u
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler, encoding s as an array of its
+// elements. Use JSON to choose another format.
+func (s Set256) MarshalJSON() ([]byte, error) { return JSON{Set: &s}.MarshalJSON() }
+
+// UnmarshalJSON implements json.Unmarshaler. It accepts every JSONFormat.
+func (s *Set256) UnmarshalJSON(data []byte) error { return (&JSON{Set: s}).UnmarshalJSON(data) }
+
+func (s *Set256) setFrom(t *SparseSet) error {
+	if m, ok := t.Max(); ok && m >= 256 {
+		return fmt.Errorf("bit: element %d out of range for Set256", m)
+	}
+	var u Set256
+	for lo, hi := range t.runs() {
+		u.AddRange(uint8(lo), uint8(hi))
+	}
+	*s = u
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler, encoding s as an array of its
+// elements. Use JSON to choose another format.
+func (s *Set) MarshalJSON() ([]byte, error) { return JSON{Set: s}.MarshalJSON() }
+
+// UnmarshalJSON implements json.Unmarshaler. It accepts every JSONFormat.
+// If s's capacity is too small for the elements, UnmarshalJSON increases it,
+// but to no more than 2^24. It returns an error for larger elements, unless
+// s already has the capacity for them.
+func (s *Set) UnmarshalJSON(data []byte) error { return (&JSON{Set: s}).UnmarshalJSON(data) }
+
+func (s *Set) setFrom(t *SparseSet) error {
+	m, ok := t.Max()
+	if ok && m >= uint64(s.decodeLimit()) {
+		return s.decodeError(m)
+	}
+	s.Clear()
+	if ok {
+		s.grow(int(m/64) + 1)
+	}
+	for lo, hi := range t.runs() {
+		s.AddRange(int(lo), int(hi))
+	}
+	return nil
+}
+
+// maxDecodedCapacity is the largest capacity that decoding JSON or Roaring
+// data grows a Set to: 2^24 elements, in 2 MiB. To decode larger elements,
+// give the Set enough capacity first. The limit keeps a small, untrusted
+// input from making a Set allocate a huge amount of memory.
+const maxDecodedCapacity = 1 << 24
+
+// decodeLimit returns the largest capacity that decoding may grow s to.
+func (s *Set) decodeLimit() int {
+	return max(s.Capacity(), maxDecodedCapacity)
+}
+
+// decodeError returns the error for decoding e into s, when e is not less
+// than s.decodeLimit().
+func (s *Set) decodeError(e uint64) error {
+	return fmt.Errorf("bit: element %d out of range for a Set of capacity %d; decoding grows a Set to a capacity of at most %d", e, s.Capacity(), maxDecodedCapacity)
+}
+
+// MarshalJSON implements json.Marshaler, encoding s as an array of its
+// elements. Use JSON to choose another format.
+func (s *SparseSet) MarshalJSON() ([]byte, error) { return JSON{Set: s}.MarshalJSON() }
+
+// UnmarshalJSON implements json.Unmarshaler. It accepts every JSONFormat.
+func (s *SparseSet) UnmarshalJSON(data []byte) error { return (&JSON{Set: s}).UnmarshalJSON(data) }
+
+func (s *SparseSet) setFrom(t *SparseSet) error {
+	*s = *t
+	return nil
+}
@@ -0,0 +1,155 @@
+package bit
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestJSONFormats(t *testing.T) {
+	s := NewSparseSet(1, 2, 3, 7, math.MaxUint64)
+	for _, test := range []struct {
+		format JSONFormat
+		want   string
+	}{
+		{JSONElements, `[1,2,3,7,18446744073709551615]`},
+		{JSONRanges, `[[1,3],[7,7],[18446744073709551615,18446744073709551615]]`},
+	} {
+		got, err := json.Marshal(JSON{Set: s, Format: test.format})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != test.want {
+			t.Errorf("format %d: got %s, want %s", test.format, got, test.want)
+		}
+	}
+	for _, f := range []JSONFormat{JSONElements, JSONRanges, JSONBinary} {
+		data, err := json.Marshal(JSON{Set: s, Format: f})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got SparseSet
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatalf("format %d: %v", f, err)
+		}
+		if !got.Equal(s) {
+			t.Errorf("format %d: got %s, want %s", f, got, s)
+		}
+	}
+	if _, err := json.Marshal(JSON{Set: s, Format: 99}); err == nil {
+		t.Error("no error for unknown format")
+	}
+
+	// The default is an array of elements.
+	var empty SparseSet
+	for _, test := range []struct {
+		v    any
+		want string
+	}{
+		{s, `[1,2,3,7,18446744073709551615]`},
+		{&empty, `[]`},
+		{sampleSet64(), `[3,17,63]`},
+		{sampleSet256(), `[3,17,63,70,192,200,201]`},
+		{newSet(128, 3, 100), `[3,100]`},
+		{s.Persistent(), `[1,2,3,7,18446744073709551615]`},
+		{struct{ S JSON }{JSON{Set: s, Format: JSONRanges}}, `{"S":[[1,3],[7,7],[18446744073709551615,18446744073709551615]]}`},
+	} {
+		got, err := json.Marshal(test.v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != test.want {
+			t.Errorf("%v: got %s, want %s", test.v, got, test.want)
+		}
+	}
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	// Every type accepts every format, and a mixture of elements and ranges.
+	bin, err := json.Marshal(JSON{Set: NewSparseSet(3, 4, 5, 9), Format: JSONBinary})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, in := range []string{
+		`[3, 4, 5, 9]`,
+		`[[3, 5], [9, 9]]`,
+		`[9, [3, 4], 5, 5]`,
+		string(bin),
+	} {
+		var s64 Set64
+		if err := json.Unmarshal([]byte(in), &s64); err != nil || s64 != Set64(1<<3|1<<4|1<<5|1<<9) {
+			t.Errorf("Set64 %s: got %s, %v", in, s64, err)
+		}
+		var s256 Set256
+		if err := json.Unmarshal([]byte(in), &s256); err != nil || !cmpSet256(s256, 3, 4, 5, 9) {
+			t.Errorf("Set256 %s: got %s, %v", in, s256, err)
+		}
+		var s Set
+		if err := json.Unmarshal([]byte(in), &s); err != nil || !s.Equal(newSet(64, 3, 4, 5, 9)) {
+			t.Errorf("Set %s: got %v, %v", in, setElements(&s), err)
+		}
+		var ss SparseSet
+		if err := json.Unmarshal([]byte(in), &ss); err != nil || !ss.Equal(NewSparseSet(3, 4, 5, 9)) {
+			t.Errorf("SparseSet %s: got %s, %v", in, ss, err)
+		}
+	}
+
+	// Decoding into a struct field that wraps a set.
+	var v struct{ S JSON }
+	v.S.Set = &Set256{}
+	if err := json.Unmarshal([]byte(`{"S": [[250, 255]]}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	if got := v.S.Set.(*Set256); !cmpSet256(*got, 250, 251, 252, 253, 254, 255) {
+		t.Errorf("got %s", got)
+	}
+
+	// A Set grows to hold the elements, up to a limit.
+	s := NewSet(64)
+	if err := json.Unmarshal([]byte(`[1000]`), s); err != nil || !s.Contains(1000) {
+		t.Errorf("Set: %v", err)
+	}
+	for _, in := range []string{`[9000000000000000000]`, `[16777216]`, `[[1, 3000000000]]`} {
+		if err := json.Unmarshal([]byte(in), s); err == nil || !s.Contains(1000) || s.Capacity() != 1024 {
+			t.Errorf("Set %s: got capacity %d, %v", in, s.Capacity(), err)
+		}
+	}
+	// Unless it already has the capacity.
+	s = NewSet(1<<24 + 64)
+	if err := json.Unmarshal([]byte(`[16777216]`), s); err != nil || !s.Contains(1<<24) {
+		t.Errorf("Set with capacity: %v", err)
+	}
+
+	for _, in := range []string{
+		`{}`, `[-1]`, `[1.5]`, `["x"]`, `[[1]]`, `[[1, 2, 3]]`, `[[5, 4]]`, `"!!"`, `"AAAA"`,
+	} {
+		ss := NewSparseSet(1)
+		if err := json.Unmarshal([]byte(in), ss); err == nil {
+			t.Errorf("%s: got %s, want error", in, ss)
+		}
+		if !ss.Equal(NewSparseSet(1)) {
+			t.Errorf("%s: set changed to %s", in, ss)
+		}
+	}
+	s64 := Set64(1)
+	if err := json.Unmarshal([]byte(`[64]`), &s64); err == nil || s64 != 1 {
+		t.Errorf("Set64: got %s, %v", s64, err)
+	}
+	var s256 Set256
+	if err := json.Unmarshal([]byte(`[[0, 256]]`), &s256); err == nil || !s256.Empty() {
+		t.Errorf("Set256: got %s, %v", s256, err)
+	}
+	// null leaves the set alone.
+	ss := NewSparseSet(1)
+	if err := json.Unmarshal([]byte(`null`), ss); err != nil || !ss.Equal(NewSparseSet(1)) {
+		t.Errorf("null: got %s, %v", ss, err)
+	}
+}
+
+func cmpSet256(s Set256, els ...uint8) bool {
+	var t Set256
+	for _, e := range els {
+		t.Add(e)
+	}
+	return s.Equal(&t)
+}
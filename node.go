@@ -1,14 +1,24 @@
 package bit
 
+import (
+	"math"
+	"math/bits"
+)
+
 // A node is a compact radix tree element.
 // It behaves like a 256-element array of subnodes, indexed by one byte of the
 // element. In fact, only the non-empty subnodes are represented; the bitset
 // field stores this set and the subnodes field contains the non-empty subnodes
 // in order.
 type node struct {
-	shift    uint // how many bits to shift elements
-	bitset   Set256
-	subnodes []subnode // if shift > 0
+	shift  uint // how many bits to shift elements
+	count  int  // number of elements in the subtree rooted at this node; see addCounts
+	bitset Set256
+	// hashCache is the hash of the subtree, or 0 if it has not been computed
+	// since the subtree last changed. Every method that changes count
+	// clears it.
+	hashCache uint64
+	subnodes  []subnode // if shift > 0
 }
 
 type subnode struct {
@@ -18,14 +28,42 @@ type subnode struct {
 
 // subber is the interface satisifed by nodes of the tree.
 // It is implemented by node, for interior nodes, and Set256, for leaves.
+// A full subtree of interior nodes is represented more compactly by full,
+// and a path of nodes with a single subnode each by chain.
 type subber interface {
-	add(uint64)
-	remove(uint64) bool // return true if empty
+	add(uint64) bool                     // return true if added
+	remove(uint64) (removed, empty bool) // empty is true if the subber is now empty
 	contains(uint64) bool
+	rank(uint64) int // number of elements less than the argument
+	nth(int) uint64  // the element of the given rank; the rank must be in range
 	elements(a []uint64, start, high uint64) int
 	size() int
 	memSize() uint64
+	hash() uint64
 	equalSub(subber) bool
+	copySub() subber
+	// The range methods operate on the closed interval [lo, hi], which must
+	// be non-empty and lie within the subber. Those that modify the subber
+	// return the result, which may be the receiver, or nil if it is empty.
+	addRangeSub(lo, hi uint64) subber
+	removeRangeSub(lo, hi uint64) subber
+	flipRangeSub(lo, hi uint64) subber
+	containsAll(lo, hi uint64) bool
+	countRange(lo, hi uint64) int
+	// intersectionSizeSub returns the number of elements in both the receiver
+	// and the argument, which must have the same dynamic type or be a full.
+	// Two chains must also have the same path. Use intersectionSize, which
+	// also handles a full argument and expands chains as needed.
+	intersectionSizeSub(subber) int
+	// subsetSub and intersectsSub are similar. Use isSubset and intersects.
+	subsetSub(subber) bool
+	intersectsSub(subber) bool
+	// The following modify the receiver in place. The argument must have the
+	// same dynamic type as the receiver, and is not modified or retained.
+	// Use unionSub and friends, which also handle full subbers and chains.
+	unionWithSub(subber)
+	differenceWithSub(subber) bool          // return true if empty
+	symmetricDifferenceWithSub(subber) bool // return true if empty
 }
 
 func (n *node) newSubber() subber {
@@ -36,45 +74,180 @@ func (n *node) newSubber() subber {
 	}
 }
 
-func (n *node) add(e uint64) {
+func (n *node) add(e uint64) bool {
 	index := uint8(e >> n.shift)
 	pos, found := n.bitset.Position(index)
 	if !found {
-		n.bitset.Add(index)
-	}
-	var sub subber
-	if found {
-		sub = n.subnodes[pos].sub
+		n.insert(pos, index, singleton(n.shift-8, e))
+	} else if c, ok := n.subnodes[pos].sub.(*chain); ok && !c.covers(e) {
+		n.subnodes[pos].sub = c.split(e)
 	} else {
-		sub = n.newSubber()
-		newsub := make([]subnode, len(n.subnodes)+1)
+		sub := n.subnodes[pos].sub
+		if !sub.add(e) {
+			return false
+		}
+		n.subnodes[pos].sub = compact(sub)
+	}
+	n.count = addCounts(n.count, 1)
+	n.hashCache = 0
+	return true
+}
+
+// insert adds sub to n's subnodes at position pos, with the given index.
+// It does not change n.count.
+//
+// When n.subnodes is full, insert grows it by half, so that adding children
+// one at a time costs amortized constant copying per child, while wasting at
+// most a third of the slice.
+func (n *node) insert(pos int, index uint8, sub subber) {
+	n.bitset.Add(index)
+	l := len(n.subnodes)
+	if l == cap(n.subnodes) {
+		newsub := make([]subnode, l+1, min(l+l/2+1, 256))
 		copy(newsub, n.subnodes[:pos])
-		newsub[pos] = subnode{index: index, sub: sub}
 		copy(newsub[pos+1:], n.subnodes[pos:])
+		newsub[pos] = subnode{index: index, sub: sub}
 		n.subnodes = newsub
+		return
 	}
-	sub.add(e)
+	n.subnodes = n.subnodes[:l+1]
+	copy(n.subnodes[pos+1:], n.subnodes[pos:l])
+	n.subnodes[pos] = subnode{index: index, sub: sub}
 }
 
-func (n *node) remove(e uint64) (empty bool) {
+// delete removes the subnode at position pos from n.
+// It does not change n.count.
+func (n *node) delete(pos int) {
+	n.bitset.Remove(n.subnodes[pos].index)
+	l := len(n.subnodes)
+	copy(n.subnodes[pos:], n.subnodes[pos+1:])
+	n.subnodes[l-1] = subnode{} // release for GC
+	n.subnodes = n.subnodes[:l-1]
+	n.shrink()
+}
+
+// shrink reallocates n.subnodes when no more than a quarter of it is used.
+// The new slice is half full, so alternately adding and removing a child
+// does not reallocate each time.
+func (n *node) shrink() {
+	l, c := len(n.subnodes), cap(n.subnodes)
+	if c >= 8 && l <= c/4 {
+		newsub := make([]subnode, l, 2*l)
+		copy(newsub, n.subnodes)
+		n.subnodes = newsub
+	}
+}
+
+// trim reallocates the subnodes of n and its descendants to remove all
+// unused capacity.
+func (n *node) trim() {
+	if len(n.subnodes) < cap(n.subnodes) {
+		newsub := make([]subnode, len(n.subnodes))
+		copy(newsub, n.subnodes)
+		n.subnodes = newsub
+	}
+	for _, sn := range n.subnodes {
+		sub := sn.sub
+		if c, ok := sub.(*chain); ok {
+			sub = c.sub
+		}
+		if c, ok := sub.(*node); ok {
+			c.trim()
+		}
+	}
+}
+
+// buildNode returns a node with the given shift holding the elements of els,
+// which must be sorted and non-empty, and must agree in the bits above those
+// the node indexes. It allocates the subnodes of each node at once, at their
+// final size.
+//
+// Each leaf is a separate allocation, as it is when elements are added one at
+// a time, so that removing a leaf frees its memory and MemSize is accurate.
+func buildNode(shift uint, els []uint64) *node {
+	groups := 1
+	for i := 1; i < len(els); i++ {
+		if els[i]>>shift != els[i-1]>>shift {
+			groups++
+		}
+	}
+	n := &node{shift: shift, subnodes: make([]subnode, 0, groups)}
+	for len(els) > 0 {
+		index := uint8(els[0] >> shift)
+		j := 1
+		for j < len(els) && uint8(els[j]>>shift) == index {
+			j++
+		}
+		var sub subber
+		if shift == 8 {
+			leaf := &Set256{}
+			for _, e := range els[:j] {
+				leaf.Add(uint8(e))
+			}
+			sub = leaf
+		} else {
+			sub = compact(buildNode(shift-8, els[:j]))
+		}
+		n.bitset.Add(index)
+		n.subnodes = append(n.subnodes, subnode{index: index, sub: sub})
+		n.count += sub.size() // at most len(els)
+		els = els[j:]
+	}
+	return n
+}
+
+// graft makes sub the subnode of the node at shift parentShift on the path
+// to e, creating nodes along the way as needed. That node must not already
+// have a subnode for e.
+func (n *node) graft(e uint64, parentShift uint, sub subber) {
+	n.count = addCounts(n.count, sub.size())
+	n.hashCache = 0
+	index := uint8(e >> n.shift)
+	pos, found := n.bitset.Position(index)
+	if n.shift == parentShift {
+		if found {
+			panic("node.graft: subnode exists")
+		}
+		n.insert(pos, index, sub)
+		return
+	}
+	if !found {
+		n.insert(pos, index, n.newSubber())
+	} else if c, ok := n.subnodes[pos].sub.(*chain); ok {
+		n.subnodes[pos].sub = c.expand()
+	}
+	c := n.subnodes[pos].sub.(*node)
+	c.graft(e, parentShift, sub)
+	n.subnodes[pos].sub = compact(c)
+}
+
+func (n *node) remove(e uint64) (removed, empty bool) {
 	// assert node is not empty
 	index := uint8(e >> n.shift)
 	pos, found := n.bitset.Position(index)
 	if !found {
-		return false // we weren't empty coming in
+		return false, false // we weren't empty coming in
 	}
 	sub := n.subnodes[pos].sub
-	if sub.remove(e) {
+	if f, ok := sub.(full); ok {
+		sub = f.expand()
+		n.subnodes[pos].sub = sub
+	}
+	removed, subEmpty := sub.remove(e)
+	if !removed {
+		return false, false
+	}
+	n.countRemoved()
+	if subEmpty {
 		if len(n.subnodes) == 1 {
 			// No need to clean up, we're finished.
-			return true
+			return true, true
 		}
-		copy(n.subnodes[pos:], n.subnodes[pos+1:])
-		// TODO: really shrink memory
-		n.subnodes = n.subnodes[:len(n.subnodes)-1]
-		n.bitset.Remove(index)
+		n.delete(pos)
+	} else {
+		n.subnodes[pos].sub = compact(sub)
 	}
-	return false
+	return true, false
 }
 
 func (n *node) contains(e uint64) bool {
@@ -87,7 +260,7 @@ func (n *node) contains(e uint64) bool {
 }
 
 func (n1 *node) equal(n2 *node) bool {
-	if !n1.bitset.Equal(&n2.bitset) {
+	if n1.count != n2.count || !n1.bitset.Equal(&n2.bitset) {
 		return false
 	}
 	for i, sn1 := range n1.subnodes {
@@ -99,21 +272,594 @@ func (n1 *node) equal(n2 *node) bool {
 }
 
 func (n1 *node) equalSub(s subber) bool {
+	switch s := s.(type) {
+	case full:
+		return n1.count == s.size()
+	case *chain:
+		return n1.equal(s.expand())
+	}
 	return n1.equal(s.(*node))
 }
 
+// copy returns a deep copy of n.
+func (n *node) copy() *node {
+	c := &node{
+		shift:    n.shift,
+		count:    n.count,
+		bitset:   n.bitset,
+		subnodes: make([]subnode, len(n.subnodes)),
+	}
+	for i, sn := range n.subnodes {
+		c.subnodes[i] = subnode{index: sn.index, sub: sn.sub.copySub()}
+	}
+	return c
+}
+
+func (n *node) copySub() subber {
+	return n.copy()
+}
+
+// unionWith sets n1 to the union of n1 and n2.
+// Subtrees of n2 that are not in n1 are copied.
+func (n1 *node) unionWith(n2 *node) { n1.merge(n2, false) }
+
+// absorb is like unionWith, but for an n2 that is no longer needed: its
+// subtrees become part of n1 instead of being copied. It may modify n2.
+func (n1 *node) absorb(n2 *node) { n1.merge(n2, true) }
+
+func (n1 *node) merge(n2 *node, take bool) {
+	union := unionSub
+	if take {
+		union = absorbSub
+	}
+	if n1.bitset == n2.bitset {
+		// Same children, so no new subnodes are needed.
+		for i, sn := range n2.subnodes {
+			n1.subnodes[i].sub = union(n1.subnodes[i].sub, sn.sub)
+		}
+		n1.recount()
+		return
+	}
+	bset := n1.bitset
+	bset.UnionWith(&n2.bitset)
+	var indices [256]uint8
+	size := bset.Elements(indices[:], 0)
+	subnodes := make([]subnode, size)
+	for i, index := range indices[:size] {
+		p1, in1 := n1.bitset.Position(index)
+		p2, in2 := n2.bitset.Position(index)
+		switch {
+		case in1 && in2:
+			sub := union(n1.subnodes[p1].sub, n2.subnodes[p2].sub)
+			subnodes[i] = subnode{index: index, sub: sub}
+		case in1:
+			subnodes[i] = n1.subnodes[p1]
+		case take:
+			subnodes[i] = n2.subnodes[p2]
+		default:
+			subnodes[i] = subnode{index: index, sub: n2.subnodes[p2].sub.copySub()}
+		}
+	}
+	n1.bitset = bset
+	n1.subnodes = subnodes
+	n1.recount()
+}
+
+func (n1 *node) unionWithSub(s subber) {
+	n1.unionWith(s.(*node))
+}
+
+// differenceWith removes the elements of n2 from n1.
+// It returns true if n1 is empty afterwards.
+func (n1 *node) differenceWith(n2 *node) (empty bool) {
+	common := n1.bitset
+	common.IntersectWith(&n2.bitset)
+	if common.Empty() {
+		return false
+	}
+	// Filter n1.subnodes in place.
+	kept := n1.subnodes[:0]
+	for _, sn := range n1.subnodes {
+		if common.Contains(sn.index) {
+			p, _ := n2.bitset.Position(sn.index)
+			sn.sub = differenceSub(sn.sub, n2.subnodes[p].sub)
+			if sn.sub == nil {
+				n1.bitset.Remove(sn.index)
+				continue
+			}
+		}
+		kept = append(kept, sn)
+	}
+	for i := len(kept); i < len(n1.subnodes); i++ {
+		n1.subnodes[i] = subnode{} // release for GC
+	}
+	n1.subnodes = kept
+	n1.shrink()
+	n1.recount()
+	return len(kept) == 0
+}
+
+func (n1 *node) differenceWithSub(s subber) bool {
+	return n1.differenceWith(s.(*node))
+}
+
+// symmetricDifferenceWith sets n1 to the elements that are in exactly one of
+// n1 and n2. It returns true if n1 is empty afterwards.
+func (n1 *node) symmetricDifferenceWith(n2 *node) (empty bool) {
+	bset := n1.bitset
+	bset.UnionWith(&n2.bitset)
+	var indices [256]uint8
+	size := bset.Elements(indices[:], 0)
+	subnodes := make([]subnode, 0, size)
+	for _, index := range indices[:size] {
+		p1, in1 := n1.bitset.Position(index)
+		p2, in2 := n2.bitset.Position(index)
+		switch {
+		case in1 && in2:
+			sub := symmetricDifferenceSub(n1.subnodes[p1].sub, n2.subnodes[p2].sub)
+			if sub == nil {
+				bset.Remove(index)
+			} else {
+				subnodes = append(subnodes, subnode{index: index, sub: sub})
+			}
+		case in1:
+			subnodes = append(subnodes, n1.subnodes[p1])
+		default:
+			subnodes = append(subnodes, subnode{index: index, sub: n2.subnodes[p2].sub.copySub()})
+		}
+	}
+	n1.bitset = bset
+	n1.subnodes = subnodes
+	n1.shrink()
+	n1.recount()
+	return len(subnodes) == 0
+}
+
+func (n1 *node) symmetricDifferenceWithSub(s subber) bool {
+	return n1.symmetricDifferenceWith(s.(*node))
+}
+
+// childRange returns the closed interval of elements that the subnode of n
+// at index can hold, clipped to [lo, hi]. The bits of lo above n's level
+// determine the interval.
+func (n *node) childRange(index uint8, lo, hi uint64) (clo, chi uint64, whole bool) {
+	mask := uint64(1)<<n.shift - 1
+	start := lo&^(mask|uint64(0xff)<<n.shift) | uint64(index)<<n.shift
+	clo, chi = start, start|mask
+	if clo < lo {
+		clo = lo
+	}
+	if chi > hi {
+		chi = hi
+	}
+	return clo, chi, clo == start && chi == start|mask
+}
+
+// fullChild returns a subber holding every element that a subnode of n
+// can hold.
+func (n *node) fullChild() subber {
+	if n.shift == 8 {
+		return fullSet256()
+	}
+	return full{shift: n.shift - 8}
+}
+
+// addRange adds [lo, hi] to n. The interval must be non-empty and lie
+// within n.
+func (n *node) addRange(lo, hi uint64) {
+	ilo, ihi := uint8(lo>>n.shift), uint8(hi>>n.shift)
+	p, _ := n.bitset.Position(ilo)
+	subnodes := make([]subnode, p, len(n.subnodes)+int(ihi-ilo)+1)
+	copy(subnodes, n.subnodes[:p])
+	for i := int(ilo); i <= int(ihi); i++ {
+		index := uint8(i)
+		var sub subber
+		if p < len(n.subnodes) && n.subnodes[p].index == index {
+			sub = n.subnodes[p].sub
+			p++
+		}
+		clo, chi, whole := n.childRange(index, lo, hi)
+		switch {
+		case whole:
+			sub = n.fullChild()
+		case sub == nil:
+			sub = n.newSubber().addRangeSub(clo, chi)
+		default:
+			sub = sub.addRangeSub(clo, chi)
+		}
+		n.bitset.Add(index)
+		subnodes = append(subnodes, subnode{index: index, sub: sub})
+	}
+	n.subnodes = append(subnodes, n.subnodes[p:]...)
+	n.recount()
+}
+
+func (n *node) addRangeSub(lo, hi uint64) subber {
+	n.addRange(lo, hi)
+	return compact(n)
+}
+
+// removeRange removes [lo, hi] from n, and reports whether n is now empty.
+// The interval must be non-empty and lie within n.
+func (n *node) removeRange(lo, hi uint64) (empty bool) {
+	return n.changeRange(lo, hi, false)
+}
+
+func (n *node) removeRangeSub(lo, hi uint64) subber {
+	if n.removeRange(lo, hi) {
+		return nil
+	}
+	return n
+}
+
+// flipRange flips the membership of each element in [lo, hi], and reports
+// whether n is now empty. The interval must be non-empty and lie within n.
+func (n *node) flipRange(lo, hi uint64) (empty bool) {
+	return n.changeRange(lo, hi, true)
+}
+
+func (n *node) flipRangeSub(lo, hi uint64) subber {
+	if n.flipRange(lo, hi) {
+		return nil
+	}
+	return compact(n)
+}
+
+// changeRange implements removeRange, and flipRange if flip is true.
+func (n *node) changeRange(lo, hi uint64, flip bool) (empty bool) {
+	ilo, ihi := uint8(lo>>n.shift), uint8(hi>>n.shift)
+	p, _ := n.bitset.Position(ilo)
+	subnodes := make([]subnode, p, len(n.subnodes)+int(ihi-ilo)+1)
+	copy(subnodes, n.subnodes[:p])
+	for i := int(ilo); i <= int(ihi); i++ {
+		index := uint8(i)
+		var sub subber
+		if p < len(n.subnodes) && n.subnodes[p].index == index {
+			sub = n.subnodes[p].sub
+			p++
+		}
+		clo, chi, whole := n.childRange(index, lo, hi)
+		switch {
+		case sub == nil && !flip:
+			continue
+		case sub == nil:
+			if whole {
+				sub = n.fullChild()
+			} else {
+				sub = n.newSubber().addRangeSub(clo, chi)
+			}
+		case whole && !flip:
+			sub = nil
+		case flip:
+			sub = sub.flipRangeSub(clo, chi)
+		default:
+			sub = sub.removeRangeSub(clo, chi)
+		}
+		if sub == nil {
+			n.bitset.Remove(index)
+			continue
+		}
+		n.bitset.Add(index)
+		subnodes = append(subnodes, subnode{index: index, sub: compact(sub)})
+	}
+	n.subnodes = append(subnodes, n.subnodes[p:]...)
+	n.shrink()
+	n.recount()
+	return len(n.subnodes) == 0
+}
+
+func (n *node) containsAll(lo, hi uint64) bool {
+	ilo, ihi := uint8(lo>>n.shift), uint8(hi>>n.shift)
+	for i := int(ilo); i <= int(ihi); i++ {
+		p, found := n.bitset.Position(uint8(i))
+		if !found {
+			return false
+		}
+		clo, chi, _ := n.childRange(uint8(i), lo, hi)
+		if !n.subnodes[p].sub.containsAll(clo, chi) {
+			return false
+		}
+	}
+	return true
+}
+
+func (n *node) countRange(lo, hi uint64) int {
+	ilo, ihi := uint8(lo>>n.shift), uint8(hi>>n.shift)
+	p, _ := n.bitset.Position(ilo)
+	c := 0
+	for _, sn := range n.subnodes[p:] {
+		if sn.index > ihi {
+			break
+		}
+		clo, chi, whole := n.childRange(sn.index, lo, hi)
+		if whole {
+			c = addCounts(c, sn.sub.size())
+		} else {
+			c = addCounts(c, sn.sub.countRange(clo, chi))
+		}
+	}
+	return c
+}
+
+// unionSub returns the union of a and b, which are subbers at the same level.
+// It may modify a, and may return it.
+func unionSub(a, b subber) subber {
+	if _, ok := a.(full); ok {
+		return a
+	}
+	if _, ok := b.(full); ok {
+		return b
+	}
+	a, b = unchain(a, b)
+	a.unionWithSub(b)
+	return compact(a)
+}
+
+// absorbSub is unionSub for a b that is no longer needed. Subtrees only in b
+// become part of the result instead of being copied. It may modify a and b.
+func absorbSub(a, b subber) subber {
+	if _, ok := a.(full); ok {
+		return a
+	}
+	if _, ok := b.(full); ok {
+		return b
+	}
+	a, b = unchain(a, b)
+	switch a := a.(type) {
+	case *node:
+		a.absorb(b.(*node))
+	case *chain:
+		a.setSub(absorbSub(a.sub, b.(*chain).sub))
+	default:
+		a.unionWithSub(b)
+	}
+	return compact(a)
+}
+
+// differenceSub returns the elements of a that are not in b, or nil if there
+// are none. It may modify a, and may return it.
+func differenceSub(a, b subber) subber {
+	if _, ok := b.(full); ok {
+		return nil
+	}
+	if f, ok := a.(full); ok {
+		a = f.expand()
+	}
+	a, b = unchain(a, b)
+	if a.differenceWithSub(b) {
+		return nil
+	}
+	return compact(a)
+}
+
+// symmetricDifferenceSub returns the elements that are in exactly one of a and
+// b, or nil if there are none. It may modify a, and may return it.
+//
+// Only a full that is paired with a partial subtree is expanded, one level at
+// a time, so the work is proportional to the size of the partial subtree.
+func symmetricDifferenceSub(a, b subber) subber {
+	if a == b {
+		// Two fulls at the same level, or the same subtree.
+		return nil
+	}
+	if f, ok := a.(full); ok {
+		a = f.expand()
+	} else if f, ok := b.(full); ok {
+		// Expand b in place of a, so that a is not expanded too.
+		// The result is the same either way round.
+		a, b = f.expand(), a
+	}
+	a, b = unchain(a, b)
+	if a.symmetricDifferenceWithSub(b) {
+		return nil
+	}
+	return compact(a)
+}
+
+// intersectionSize returns the number of elements in both a and b, which are
+// subbers at the same level.
+func intersectionSize(a, b subber) int {
+	if _, ok := b.(full); ok {
+		return a.size()
+	}
+	a, b = unchain(a, b)
+	return a.intersectionSizeSub(b)
+}
+
+func (n1 *node) intersectionSizeSub(s subber) int {
+	n2 := s.(*node)
+	if n1 == n2 {
+		return n1.count
+	}
+	// Walk the two subnode lists in step, like a merge.
+	c := 0
+	sns1, sns2 := n1.subnodes, n2.subnodes
+	for len(sns1) > 0 && len(sns2) > 0 {
+		switch sn1, sn2 := sns1[0], sns2[0]; {
+		case sn1.index < sn2.index:
+			sns1 = sns1[1:]
+		case sn1.index > sn2.index:
+			sns2 = sns2[1:]
+		default:
+			c = addCounts(c, intersectionSize(sn1.sub, sn2.sub))
+			sns1, sns2 = sns1[1:], sns2[1:]
+		}
+	}
+	return c
+}
+
+// isSubset reports whether every element of a is in b, which are subbers at
+// the same level.
+func isSubset(a, b subber) bool {
+	if _, ok := b.(full); ok {
+		return true
+	}
+	a, b = unchain(a, b)
+	return a.subsetSub(b)
+}
+
+func (n1 *node) subsetSub(s subber) bool {
+	n2 := s.(*node)
+	if n1 == n2 {
+		return true
+	}
+	if n1.count > n2.count || !n1.bitset.IsSubsetOf(&n2.bitset) {
+		return false
+	}
+	// Every subnode of n1 has a partner in n2.
+	sns2 := n2.subnodes
+	for _, sn1 := range n1.subnodes {
+		for sns2[0].index < sn1.index {
+			sns2 = sns2[1:]
+		}
+		if !isSubset(sn1.sub, sns2[0].sub) {
+			return false
+		}
+		sns2 = sns2[1:]
+	}
+	return true
+}
+
+// intersects reports whether a and b, which are subbers at the same level,
+// have an element in common.
+func intersects(a, b subber) bool {
+	if _, ok := b.(full); ok {
+		return true
+	}
+	a, b = unchain(a, b)
+	return a.intersectsSub(b)
+}
+
+func (n1 *node) intersectsSub(s subber) bool {
+	n2 := s.(*node)
+	if n1 == n2 {
+		return true
+	}
+	if !n1.bitset.Intersects(&n2.bitset) {
+		return false
+	}
+	sns1, sns2 := n1.subnodes, n2.subnodes
+	for len(sns1) > 0 && len(sns2) > 0 {
+		switch sn1, sn2 := sns1[0], sns2[0]; {
+		case sn1.index < sn2.index:
+			sns1 = sns1[1:]
+		case sn1.index > sn2.index:
+			sns2 = sns2[1:]
+		default:
+			if intersects(sn1.sub, sn2.sub) {
+				return true
+			}
+			sns1, sns2 = sns1[1:], sns2[1:]
+		}
+	}
+	return false
+}
+
+// compact returns a full if s is a node containing every possible element,
+// a chain if s is a node with a single subnode, and s otherwise.
+func compact(s subber) subber {
+	n, ok := s.(*node)
+	switch {
+	case !ok:
+		return s
+	case n.count < math.MaxInt && n.count == fullSize(n.shift):
+		return full{shift: n.shift}
+	case len(n.subnodes) == 1:
+		sn := n.subnodes[0]
+		return newChain(n.shift, uint64(sn.index)<<n.shift, sn.sub)
+	}
+	return s
+}
+
+// A set can hold up to 2^64 elements, more than an int can count, so counts
+// of elements saturate: a count of math.MaxInt means at least that many.
+// Only the nodes at the top of a tree can hold that many elements.
+
+// addCounts returns a+b, or math.MaxInt if that is larger.
+// a and b must not be negative.
+func addCounts(a, b int) int {
+	if a > math.MaxInt-b {
+		return math.MaxInt
+	}
+	return a + b
+}
+
+// intCount returns u, or math.MaxInt if that is smaller.
+func intCount(u uint64) int {
+	if u > math.MaxInt {
+		return math.MaxInt
+	}
+	return int(u)
+}
+
+// fullSize returns the number of elements in a full subtree whose root has
+// the given shift, saturated like any other count.
+func fullSize(shift uint) int {
+	if shift+8 >= bits.UintSize-1 {
+		return math.MaxInt
+	}
+	return 1 << (shift + 8)
+}
+
 func (n *node) size() int {
+	return n.count
+}
+
+// recount recomputes n.count from n's subnodes.
+func (n *node) recount() {
 	t := 0
 	for _, s := range n.subnodes {
-		t += s.sub.size()
+		t = addCounts(t, s.sub.size())
+	}
+	n.count = t
+	n.hashCache = 0
+}
+
+// countRemoved updates n.count after an element has been removed from one
+// of n's subtrees.
+func (n *node) countRemoved() {
+	if n.count == math.MaxInt {
+		// The count saturated, so n may still have too many elements to
+		// count.
+		n.recount()
+		return
+	}
+	n.count--
+	n.hashCache = 0
+}
+
+func (n *node) rank(e uint64) int {
+	index := uint8(e >> n.shift)
+	p, found := n.bitset.Position(index)
+	r := 0
+	for _, s := range n.subnodes[:p] {
+		r = addCounts(r, s.sub.size())
+	}
+	if found {
+		r = addCounts(r, n.subnodes[p].sub.rank(e))
+	}
+	return r
+}
+
+func (n *node) nth(k int) uint64 {
+	for _, s := range n.subnodes {
+		sz := s.sub.size()
+		if k < sz {
+			return uint64(s.index)<<n.shift | s.sub.nth(k)
+		}
+		k -= sz
 	}
-	return t
+	panic("node.nth: rank out of range")
 }
 
+// memSize returns the memory allocated for n and its descendants: n itself,
+// the backing array of n.subnodes, and the subtrees. Each subnode holds an
+// interface value; the leaves and nodes they point to are separate
+// allocations, counted by their own memSize methods.
 func (n *node) memSize() uint64 {
-	sz := memSize(*n)
+	sz := allocSize(memSize(*n))
+	sz += allocSize(uint64(cap(n.subnodes)) * memSize(subnode{}))
 	for _, s := range n.subnodes {
-		sz += memSize(s)
 		sz += s.sub.memSize()
 	}
 	return sz
@@ -131,7 +877,7 @@ func (n *node) elements(a []uint64, start, high uint64) int {
 		total = n.subnodes[p].sub.elements(a, start, hi(p))
 		p++
 	}
-	for i := p; i < len(n.subnodes); i++ {
+	for i := p; i < len(n.subnodes) && total < len(a); i++ {
 		total += n.subnodes[i].sub.elements(a[total:], 0, hi(i))
 	}
 	return total
@@ -204,34 +950,47 @@ func intersectNodes(nodes []*node) *node {
 	var subsets [256]*Set256
 	isSets := (nodes[0].shift == 8)
 	for _, index := range indices[:size] {
-		for i, n := range nodes {
+		// Full subtrees don't affect the intersection, so leave them out.
+		nsubs := 0
+		for _, n := range nodes {
 			p, found := n.bitset.Position(index)
 			if !found {
 				panic("intersectNodes: index not found")
 			}
-			sub := n.subnodes[p].sub
-			if isSets {
-				subsets[i] = sub.(*Set256)
-			} else {
-				subnodes[i] = sub.(*node)
+			switch sub := n.subnodes[p].sub.(type) {
+			case *Set256:
+				subsets[nsubs] = sub
+				nsubs++
+			case *node:
+				subnodes[nsubs] = sub
+				nsubs++
+			case *chain:
+				subnodes[nsubs] = sub.expand()
+				nsubs++
 			}
 		}
 		var newsub subber
-		if isSets {
+		switch {
+		case nsubs == 0:
+			newsub = full{shift: nodes[0].shift - 8}
+		case isSets:
 			var bs Set256
-			bs.IntersectN(subsets[:len(nodes)])
+			bs.IntersectN(subsets[:nsubs])
 			if !bs.Empty() {
 				newsub = &bs
 			}
-		} else {
-			in := intersectNodes(subnodes[:len(nodes)])
+		case nsubs == 1:
+			newsub = subnodes[0].copy()
+		default:
+			in := intersectNodes(subnodes[:nsubs])
 			if in != nil {
 				newsub = in
 			}
 		}
 		if newsub != nil {
 			result.subnodes = append(result.subnodes,
-				subnode{index: index, sub: newsub})
+				subnode{index: index, sub: compact(newsub)})
+			result.count = addCounts(result.count, newsub.size())
 		} else {
 			// Although all the nodes have an item at this position,
 			// the intersection of those items is empty.
@@ -0,0 +1,531 @@
+package bit
+
+import "iter"
+
+// A PersistentSet is an immutable set of uint64s, using the same radix tree
+// as SparseSet.
+//
+// Operations that would modify a SparseSet instead return a new
+// PersistentSet. The new set shares every unchanged subtree with the old
+// one; only the nodes on the path to a change are copied. So keeping a
+// PersistentSet is a constant-time snapshot, and a PersistentSet may be read
+// by any number of goroutines while another goroutine derives new versions
+// from it.
+//
+// The zero value is an empty set.
+type PersistentSet struct {
+	s SparseSet // never modified after construction
+}
+
+// NewPersistentSet returns a PersistentSet with the given elements.
+func NewPersistentSet(els ...uint64) *PersistentSet {
+	return NewSparseSet(els...).persistent()
+}
+
+// Persistent returns a PersistentSet with the same elements as s.
+// It takes time proportional to the size of s's tree. Later changes to s do
+// not affect the result.
+func (s *SparseSet) Persistent() *PersistentSet {
+	return s.Copy().persistent()
+}
+
+// persistent returns a PersistentSet that takes ownership of s's tree.
+func (s *SparseSet) persistent() *PersistentSet {
+	return &PersistentSet{s: *s}
+}
+
+// SparseSet returns a SparseSet with the same elements as p.
+// It takes time proportional to the size of p's tree.
+func (p *PersistentSet) SparseSet() *SparseSet {
+	return p.s.Copy()
+}
+
+// Add returns a set with the elements of p and n.
+// If n is already in p, Add returns p.
+func (p *PersistentSet) Add(n uint64) *PersistentSet {
+	if p.s.root == nil {
+		return NewPersistentSet(n)
+	}
+	s := p.s
+	s.grow(n)
+	r := s.root.with(n)
+	if r == p.s.root {
+		return p
+	}
+	return &PersistentSet{s: SparseSet{root: r, prefix: s.prefix}}
+}
+
+// Remove returns a set with the elements of p other than n.
+// If n is not in p, Remove returns p.
+func (p *PersistentSet) Remove(n uint64) *PersistentSet {
+	if !p.s.covers(n) {
+		return p
+	}
+	r := p.s.root.without(n)
+	if r == p.s.root {
+		return p
+	}
+	return newPersistentSet(r, p.s.prefix)
+}
+
+// Union returns the union of p and q.
+func (p *PersistentSet) Union(q *PersistentSet) *PersistentSet {
+	return p.combine(q, unionShared)
+}
+
+// Intersect returns the intersection of p and q.
+func (p *PersistentSet) Intersect(q *PersistentSet) *PersistentSet {
+	return p.combine(q, intersectShared)
+}
+
+// Difference returns the elements of p that are not in q.
+func (p *PersistentSet) Difference(q *PersistentSet) *PersistentSet {
+	return p.combine(q, differenceShared)
+}
+
+// SymmetricDifference returns the elements that are in exactly one of p and q.
+func (p *PersistentSet) SymmetricDifference(q *PersistentSet) *PersistentSet {
+	return p.combine(q, symmetricDifferenceShared)
+}
+
+// combine applies f, one of the shared set operations, to the trees of p
+// and q. If the result is the same as p or q, it returns that set.
+func (p *PersistentSet) combine(q *PersistentSet, f func(a, b subber) subber) *PersistentSet {
+	a, b := rootSubber(p.s.root), rootSubber(q.s.root)
+	var prefix uint64
+	switch {
+	case a == nil:
+		prefix = q.s.prefix
+	case b == nil:
+		prefix = p.s.prefix
+	default:
+		// Bring the roots to the same height.
+		shift := commonRootShift(&p.s, &q.s)
+		var an, bn *node
+		an, prefix = p.s.lifted(shift)
+		bn, _ = q.s.lifted(shift)
+		a, b = an, bn
+	}
+	r := f(a, b)
+	switch r := r.(type) {
+	case nil:
+		return &PersistentSet{}
+	case full:
+		// Every element the root can hold is present.
+		return newPersistentSet(r.expand(), prefix)
+	case *chain:
+		return newPersistentSet(r.root(prefix))
+	}
+	switch r {
+	case a:
+		return p
+	case b:
+		return q
+	}
+	return newPersistentSet(r.(*node), prefix)
+}
+
+// newPersistentSet returns a PersistentSet whose tree has root r, which may
+// be nil, and the given prefix. It removes unneeded nodes from the top of the
+// tree, without modifying them.
+func newPersistentSet(r *node, prefix uint64) *PersistentSet {
+	p := &PersistentSet{}
+	if r != nil {
+		p.s = SparseSet{root: r, prefix: prefix}
+		p.s.trimRoot()
+	}
+	return p
+}
+
+// rootSubber converts a possibly nil root to a subber, avoiding a non-nil
+// interface holding a nil pointer.
+func rootSubber(n *node) subber {
+	if n == nil {
+		return nil
+	}
+	return n
+}
+
+// The methods below only read the set, so they can use SparseSet's.
+
+func (p *PersistentSet) Contains(n uint64) bool { return p.s.Contains(n) }
+
+func (p *PersistentSet) Empty() bool { return p.s.Empty() }
+
+func (p *PersistentSet) Size() int { return p.s.Size() }
+
+func (p *PersistentSet) Equal(q *PersistentSet) bool { return p.s.Equal(&q.s) }
+
+func (p *PersistentSet) IntersectionSize(q *PersistentSet) int { return p.s.IntersectionSize(&q.s) }
+
+func (p *PersistentSet) UnionSize(q *PersistentSet) int { return p.s.UnionSize(&q.s) }
+
+func (p *PersistentSet) IsSubsetOf(q *PersistentSet) bool { return p.s.IsSubsetOf(&q.s) }
+
+func (p *PersistentSet) IsSupersetOf(q *PersistentSet) bool { return p.s.IsSupersetOf(&q.s) }
+
+func (p *PersistentSet) Intersects(q *PersistentSet) bool { return p.s.Intersects(&q.s) }
+
+func (p *PersistentSet) IsDisjoint(q *PersistentSet) bool { return p.s.IsDisjoint(&q.s) }
+
+func (p *PersistentSet) Compare(q *PersistentSet) int { return p.s.Compare(&q.s) }
+
+func (p *PersistentSet) Hash() uint64 { return p.s.Hash() }
+
+func (p *PersistentSet) Elements(a []uint64, start uint64) int { return p.s.Elements(a, start) }
+
+func (p *PersistentSet) Iterator() *SparseSetIterator { return p.s.Iterator() }
+
+func (p *PersistentSet) All() iter.Seq[uint64] { return p.s.All() }
+
+func (p *PersistentSet) From(start uint64) iter.Seq[uint64] { return p.s.From(start) }
+
+func (p *PersistentSet) Backward() iter.Seq[uint64] { return p.s.Backward() }
+
+func (p *PersistentSet) Rank(n uint64) int { return p.s.Rank(n) }
+
+func (p *PersistentSet) Select(k int) (uint64, bool) { return p.s.Select(k) }
+
+func (p *PersistentSet) Min() (uint64, bool) { return p.s.Min() }
+
+func (p *PersistentSet) Max() (uint64, bool) { return p.s.Max() }
+
+func (p *PersistentSet) NextAfter(n uint64) (uint64, bool) { return p.s.NextAfter(n) }
+
+func (p *PersistentSet) PrevBefore(n uint64) (uint64, bool) { return p.s.PrevBefore(n) }
+
+func (p *PersistentSet) ReverseIterator() *SparseSetReverseIterator { return p.s.ReverseIterator() }
+
+func (p *PersistentSet) ContainsAll(lo, hi uint64) bool { return p.s.ContainsAll(lo, hi) }
+
+func (p *PersistentSet) Count(lo, hi uint64) int { return p.s.Count(lo, hi) }
+
+func (p *PersistentSet) MemSize() uint64 { return p.s.MemSize() }
+
+func (p *PersistentSet) Stats() TreeStats { return p.s.Stats() }
+
+func (p *PersistentSet) MarshalBinary() ([]byte, error) { return p.s.MarshalBinary() }
+
+func (p *PersistentSet) MarshalJSON() ([]byte, error) { return p.s.MarshalJSON() }
+
+func (p *PersistentSet) MarshalText() ([]byte, error) { return p.s.MarshalText() }
+
+func (p *PersistentSet) String() string { return p.s.String() }
+
+// The functions below never modify their arguments. Their results may share
+// subtrees with them.
+
+// with returns a node with the elements of n and e.
+// If e is already in n, it returns n.
+func (n *node) with(e uint64) *node {
+	index := uint8(e >> n.shift)
+	pos, found := n.bitset.Position(index)
+	if !found {
+		c := n.shallowCopy(1)
+		c.insert(pos, index, singleton(n.shift-8, e))
+		c.count = addCounts(c.count, 1)
+		return c
+	}
+	old := n.subnodes[pos].sub
+	sub := withSub(old, e)
+	if sub == old {
+		return n
+	}
+	c := n.shallowCopy(0)
+	c.subnodes[pos].sub = compact(sub)
+	c.count = addCounts(c.count, 1)
+	return c
+}
+
+// with returns a subber with the elements of c and e.
+// If e is already in c, it returns c.
+func (c *chain) with(e uint64) subber {
+	if !c.covers(e) {
+		return c.split(e)
+	}
+	sub := withSub(c.sub, e)
+	if sub == c.sub {
+		return c
+	}
+	return &chain{shift: c.shift, prefix: c.prefix, sub: compact(sub)}
+}
+
+// withSub returns a subber with the elements of s and e.
+// If e is already in s, it returns s.
+func withSub(s subber, e uint64) subber {
+	switch s := s.(type) {
+	case *node:
+		return s.with(e)
+	case *chain:
+		return s.with(e)
+	case *Set256:
+		if !s.Contains(uint8(e)) {
+			t := *s
+			t.Add(uint8(e))
+			return &t
+		}
+	}
+	return s
+}
+
+// without returns a node with the elements of n other than e, or nil
+// if there are none. If e is not in n, it returns n.
+func (n *node) without(e uint64) *node {
+	index := uint8(e >> n.shift)
+	pos, found := n.bitset.Position(index)
+	if !found {
+		return n
+	}
+	old := n.subnodes[pos].sub
+	sub := withoutSub(old, e)
+	if sub == old {
+		return n
+	}
+	if sub == nil && len(n.subnodes) == 1 {
+		return nil
+	}
+	c := n.shallowCopy(0)
+	if sub == nil {
+		c.bitset.Remove(index)
+		c.subnodes = append(c.subnodes[:pos], c.subnodes[pos+1:]...)
+	} else {
+		c.subnodes[pos].sub = compact(sub)
+	}
+	c.countRemoved()
+	return c
+}
+
+// without returns a subber with the elements of c other than e, or nil
+// if there are none. If e is not in c, it returns c.
+func (c *chain) without(e uint64) subber {
+	if !c.covers(e) {
+		return c
+	}
+	sub := withoutSub(c.sub, e)
+	switch sub {
+	case nil:
+		return nil
+	case c.sub:
+		return c
+	}
+	return newChain(c.shift, c.prefix, compact(sub))
+}
+
+// withoutSub returns a subber with the elements of s other than e, or nil
+// if there are none. If e is not in s, it returns s.
+func withoutSub(s subber, e uint64) subber {
+	switch s := s.(type) {
+	case full:
+		x := s.expand()
+		x.remove(e)
+		return x
+	case *node:
+		if x := s.without(e); x != nil {
+			return x
+		}
+		return nil
+	case *chain:
+		return s.without(e)
+	case *Set256:
+		if !s.Contains(uint8(e)) {
+			return s
+		}
+		t := *s
+		t.Remove(uint8(e))
+		if !t.Empty() {
+			return &t
+		}
+	}
+	return nil
+}
+
+// shallowCopy returns a copy of n that shares n's subtrees, with room for
+// extra more subnodes.
+func (n *node) shallowCopy(extra int) *node {
+	// Copy field by field: n may be shared with a goroutine that is caching
+	// its hash, and the copy starts with no cached hash anyway.
+	c := &node{shift: n.shift, count: n.count, bitset: n.bitset}
+	c.subnodes = make([]subnode, len(n.subnodes), len(n.subnodes)+extra)
+	copy(c.subnodes, n.subnodes)
+	return c
+}
+
+// The shared set operations take two subbers at the same level, either of
+// which may be nil to represent the empty set. They return the result, or nil
+// if it is empty.
+
+func unionShared(a, b subber) subber {
+	switch {
+	case a == nil || a == b:
+		return b
+	case b == nil:
+		return a
+	}
+	if _, ok := a.(full); ok {
+		return a
+	}
+	if _, ok := b.(full); ok {
+		return b
+	}
+	if a, ok := a.(*Set256); ok {
+		return sharedSet256(*a, (*Set256).UnionWith, a, b.(*Set256))
+	}
+	return mergeSubs(a, b, unionShared, true, true)
+}
+
+func intersectShared(a, b subber) subber {
+	switch {
+	case a == nil || b == nil:
+		return nil
+	case a == b:
+		return a
+	}
+	if _, ok := a.(full); ok {
+		return b
+	}
+	if _, ok := b.(full); ok {
+		return a
+	}
+	if a, ok := a.(*Set256); ok {
+		return sharedSet256(*a, (*Set256).IntersectWith, a, b.(*Set256))
+	}
+	return mergeSubs(a, b, intersectShared, false, false)
+}
+
+func differenceShared(a, b subber) subber {
+	switch {
+	case a == nil || a == b:
+		return nil
+	case b == nil:
+		return a
+	}
+	if _, ok := b.(full); ok {
+		return nil
+	}
+	if f, ok := a.(full); ok {
+		a = f.expand()
+	}
+	if a, ok := a.(*Set256); ok {
+		return sharedSet256(*a, (*Set256).DifferenceWith, a, b.(*Set256))
+	}
+	return mergeSubs(a, b, differenceShared, true, false)
+}
+
+func symmetricDifferenceShared(a, b subber) subber {
+	switch {
+	case a == b:
+		return nil
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	}
+	if f, ok := a.(full); ok {
+		a = f.expand()
+	}
+	if f, ok := b.(full); ok {
+		b = f.expand()
+	}
+	if a, ok := a.(*Set256); ok {
+		return sharedSet256(*a, (*Set256).SymmetricDifferenceWith, a, b.(*Set256))
+	}
+	return mergeSubs(a, b, symmetricDifferenceShared, true, true)
+}
+
+// sharedSet256 returns the result of applying op to c, a copy of a, and b.
+// It returns a or b instead of a new Set256 if the result is equal to one of
+// them, and nil if it is empty.
+func sharedSet256(c Set256, op func(c, b *Set256), a, b *Set256) subber {
+	op(&c, b)
+	switch {
+	case c.Empty():
+		return nil
+	case c == *a:
+		return a
+	case c == *b:
+		return b
+	}
+	return &c
+}
+
+// mergeSubs is mergeShared for a and b, nodes or chains at the same level. A
+// chain is expanded unless the other has the same path, and a and b are
+// returned in place of their expansions, so that the result is compact.
+func mergeSubs(a, b subber, op func(a, b subber) subber, keepA, keepB bool) subber {
+	ua, ub := unchain(a, b)
+	if c, ok := ua.(*chain); ok {
+		return sharedChain(c, ub.(*chain), op)
+	}
+	switch r := mergeShared(ua.(*node), ub.(*node), op, keepA, keepB); r {
+	case ua:
+		return a
+	case ub:
+		return b
+	default:
+		return r
+	}
+}
+
+// sharedChain returns the result of applying op to the subtrees at the ends of
+// a and b, which are chains with the same path. It returns a or b if the
+// result is the same as one of them, and nil if it is empty.
+func sharedChain(a, b *chain, op func(a, b subber) subber) subber {
+	sub := op(a.sub, b.sub)
+	switch sub {
+	case nil:
+		return nil
+	case a.sub:
+		return a
+	case b.sub:
+		return b
+	}
+	return newChain(a.shift, a.prefix, sub)
+}
+
+// mergeShared combines the subnodes of a and b with op, one of the shared
+// set operations. If keepA is true, subnodes only in a are part of the
+// result, and similarly for keepB. mergeShared returns a or b instead of a
+// new node if the result would be the same, and nil if it is empty.
+func mergeShared(a, b *node, op func(a, b subber) subber, keepA, keepB bool) subber {
+	var bset Set256
+	switch {
+	case keepA && keepB:
+		bset = a.bitset
+		bset.UnionWith(&b.bitset)
+	case keepA:
+		bset = a.bitset
+	default:
+		bset = a.bitset
+		bset.IntersectWith(&b.bitset)
+	}
+	var indices [256]uint8
+	size := bset.Elements(indices[:], 0)
+	c := &node{shift: a.shift, subnodes: make([]subnode, 0, size)}
+	sameA, sameB := true, true
+	for _, index := range indices[:size] {
+		var sa, sb subber
+		if p, found := a.bitset.Position(index); found {
+			sa = a.subnodes[p].sub
+		}
+		if p, found := b.bitset.Position(index); found {
+			sb = b.subnodes[p].sub
+		}
+		sub := op(sa, sb)
+		sameA = sameA && sub == sa
+		sameB = sameB && sub == sb
+		if sub != nil {
+			c.bitset.Add(index)
+			c.subnodes = append(c.subnodes, subnode{index: index, sub: sub})
+			c.count = addCounts(c.count, sub.size())
+		}
+	}
+	switch {
+	case len(c.subnodes) == 0:
+		return nil
+	case sameA && c.bitset == a.bitset:
+		return a
+	case sameB && c.bitset == b.bitset:
+		return b
+	}
+	return compact(c)
+}
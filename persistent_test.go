@@ -0,0 +1,128 @@
+package bit
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestPersistentAddRemove(t *testing.T) {
+	// Keep every version, and check that later changes don't affect them.
+	var versions []*PersistentSet
+	var models []*SparseSet
+	p := &PersistentSet{}
+	m := &SparseSet{}
+	for i := 0; i < 2000; i++ {
+		e := uint64(rand.Intn(3000))
+		if i%7 == 0 {
+			e = randUint64()
+		}
+		if rand.Intn(3) == 0 {
+			p = p.Remove(e)
+			m.Remove(e)
+		} else {
+			p = p.Add(e)
+			m.Add(e)
+		}
+		if i%100 == 0 {
+			versions = append(versions, p)
+			models = append(models, m.Copy())
+		}
+	}
+	for i, v := range versions {
+		if !v.s.Equal(models[i]) || v.Size() != models[i].Size() {
+			t.Fatalf("version %d changed", i)
+		}
+	}
+}
+
+func TestPersistentSharing(t *testing.T) {
+	p := NewPersistentSet()
+	for i := uint64(0); i < 10000; i++ {
+		p = p.Add(i * 1000)
+	}
+	if p.Add(5000) != p {
+		t.Error("adding an existing element made a new set")
+	}
+	if p.Remove(5001) != p {
+		t.Error("removing a missing element made a new set")
+	}
+	q := p.Add(5001)
+	if !q.Contains(5001) || p.Contains(5001) {
+		t.Fatal("bad Add")
+	}
+	// Only the path to 5001 should have been copied.
+	shared := 0
+	pn, qn := p.s.root, q.s.root
+	for pn.shift > 8 {
+		pn = pn.subnodes[0].sub.(*node)
+		qn = qn.subnodes[0].sub.(*node)
+	}
+	for i, sn := range pn.subnodes {
+		if sn.sub == qn.subnodes[i].sub {
+			shared++
+		}
+	}
+	if want := len(pn.subnodes) - 1; shared != want {
+		t.Errorf("%d leaves shared, want %d", shared, want)
+	}
+	if r := q.Remove(5001); !r.Equal(p) {
+		t.Error("Remove did not undo Add")
+	}
+}
+
+func TestPersistentSetOps(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		s1, s2 := randSparseSet(300), randSparseSet(300)
+		if i%5 == 0 {
+			s1.AddRange(1000, 1<<17)
+		}
+		if i%4 == 0 {
+			s2.AddRange(1<<16, 1<<18)
+		}
+		p1, p2 := s1.Persistent(), s2.Persistent()
+		for _, test := range []struct {
+			name string
+			got  *PersistentSet
+			op   func(s *SparseSet, ss ...*SparseSet)
+		}{
+			{"union", p1.Union(p2), (*SparseSet).Union},
+			{"intersect", p1.Intersect(p2), (*SparseSet).Intersect},
+			{"difference", p1.Difference(p2), (*SparseSet).Difference},
+			{"symmetric difference", p1.SymmetricDifference(p2), (*SparseSet).SymmetricDifference},
+		} {
+			var want SparseSet
+			test.op(&want, s1, s2)
+			if !test.got.s.Equal(&want) || test.got.Size() != want.Size() {
+				t.Fatalf("%s: wrong result", test.name)
+			}
+		}
+		// The operands are unchanged.
+		if !p1.s.Equal(s1) || !p2.s.Equal(s2) {
+			t.Fatal("operand changed")
+		}
+		if p1.Union(&PersistentSet{}) != p1 || p1.Intersect(p1) != p1 {
+			t.Error("trivial operation made a new set")
+		}
+	}
+}
+
+func TestPersistentHeight(t *testing.T) {
+	p := NewPersistentSet(1, 2)
+	q := p.Add(1 << 60)
+	if p.Contains(1<<60) || p.Size() != 2 {
+		t.Errorf("p changed: %s", p)
+	}
+	if !q.Contains(1<<60) || !q.Contains(2) || q.Size() != 3 {
+		t.Errorf("q = %s", q)
+	}
+	r := q.Remove(1 << 60)
+	if !r.Equal(p) || len(r.Stats().Levels) != 1 {
+		t.Errorf("r = %s, with %d levels", r, len(r.Stats().Levels))
+	}
+	if p.Remove(1<<60) != p {
+		t.Error("removing an absent large element made a new set")
+	}
+	if p.Union(p.Intersect(q)) != p {
+		t.Error("Union of a subset of p did not return p")
+	}
+}
@@ -0,0 +1,546 @@
+package bit
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+// This file implements the portable Roaring serialization format, described at
+// https://github.com/RoaringBitmap/RoaringFormatSpec.
+//
+// A 32-bit Roaring bitmap is a sequence of containers, each holding the
+// elements that share the same high 16 bits. A container corresponds exactly
+// to a node at shift 8 in a SparseSet tree, and to 1024 consecutive words of
+// a Set.
+//
+// The 64-bit format is the one used by CRoaring and the Go roaring64 package:
+// a little-endian 64-bit count of 32-bit bitmaps, followed by each bitmap
+// preceded by the little-endian 32-bit value of its elements' high bits.
+
+const (
+	roaringCookieNoRun    = 12346
+	roaringCookie         = 12347
+	roaringNoOffsetThresh = 4
+	roaringMaxArraySize   = 4096
+	roaringMaxContainers  = 1 << 16
+)
+
+var errCorruptRoaring = errors.New("bit: corrupt Roaring bitmap")
+
+// A container is a view of one 16-bit Roaring container, or of a run of
+// full containers with consecutive keys.
+type container struct {
+	key  uint64 // the elements' bits above the low 16
+	card int
+	fill func(words *[1024]uint64) // sets words to the container's bitmap
+	// n is the number of containers in the run, with keys key, key+1, and so
+	// on. It is more than 1 only for full containers, so that a large full
+	// subtree does not need a container value for every 2^16 elements.
+	n uint64
+}
+
+// MarshalRoaring32 returns the portable 32-bit Roaring serialization of s.
+// It returns an error if s has an element that does not fit in 32 bits.
+func (s *SparseSet) MarshalRoaring32() ([]byte, error) {
+	if m, ok := s.Max(); ok && m >= 1<<32 {
+		return nil, errors.New("bit: SparseSet has elements too large for a 32-bit Roaring bitmap")
+	}
+	return appendRoaring32(nil, s.containers()), nil
+}
+
+// MarshalRoaring64 returns the portable 64-bit Roaring serialization of s.
+func (s *SparseSet) MarshalRoaring64() ([]byte, error) {
+	return appendRoaring64(nil, s.containers()), nil
+}
+
+// UnmarshalRoaring32 replaces the contents of s with the elements of a
+// portable 32-bit Roaring bitmap. If data is invalid, UnmarshalRoaring32
+// returns an error and leaves s unchanged.
+func (s *SparseSet) UnmarshalRoaring32(data []byte) error {
+	var t SparseSet
+	if err := parseRoaring(data, false, t.graftContainer); err != nil {
+		return err
+	}
+	t.trimRoot()
+	*s = t
+	return nil
+}
+
+// UnmarshalRoaring64 replaces the contents of s with the elements of a
+// portable 64-bit Roaring bitmap. If data is invalid, UnmarshalRoaring64
+// returns an error and leaves s unchanged.
+func (s *SparseSet) UnmarshalRoaring64(data []byte) error {
+	var t SparseSet
+	if err := parseRoaring(data, true, t.graftContainer); err != nil {
+		return err
+	}
+	t.trimRoot()
+	*s = t
+	return nil
+}
+
+// containers returns the Roaring containers of s, in order.
+func (s *SparseSet) containers() []container {
+	var cs []container
+	// walk adds the containers of sub, which is at the level of a node at
+	// shift 8 or higher.
+	var walk func(sub subber, high uint64)
+	walk = func(sub subber, high uint64) {
+		switch c := sub.(type) {
+		case full:
+			cs = append(cs, container{key: high >> 16, card: 1 << 16, fill: fillFull, n: c.mask()>>16 + 1})
+		case *chain:
+			if level(c.sub) < 16 {
+				// The chain ends in a leaf, which is the only part of the
+				// container.
+				cs = append(cs, container{key: (high | c.prefix) >> 16, card: c.size(), fill: c.fillWords, n: 1})
+			} else {
+				walk(c.sub, high|c.prefix)
+			}
+		case *node:
+			if c.shift == 8 {
+				cs = append(cs, container{key: high >> 16, card: c.count, fill: c.fillWords, n: 1})
+				return
+			}
+			for _, sn := range c.subnodes {
+				walk(sn.sub, high|uint64(sn.index)<<c.shift)
+			}
+		}
+	}
+	if s.root != nil {
+		// Containers are the subtrees of nodes at shift 16.
+		walk(s.lifted(16))
+	}
+	return cs
+}
+
+// fillWords sets words to the bitmap of n, which must be at shift 8.
+func (n *node) fillWords(words *[1024]uint64) {
+	*words = [1024]uint64{}
+	for _, sn := range n.subnodes {
+		leaf := sn.sub.(*Set256)
+		for i, t := range leaf.sets {
+			words[int(sn.index)*4+i] = uint64(t)
+		}
+	}
+}
+
+// fillWords sets words to the bitmap of c, which must end in a leaf.
+func (c *chain) fillWords(words *[1024]uint64) {
+	*words = [1024]uint64{}
+	leaf := c.sub.(*Set256)
+	i := int(uint8(c.prefix >> 8))
+	for j, t := range leaf.sets {
+		words[i*4+j] = uint64(t)
+	}
+}
+
+func fillFull(words *[1024]uint64) {
+	for i := range words {
+		words[i] = ^uint64(0)
+	}
+}
+
+// graftContainer adds the elements of a container to s, which must not
+// already have any elements with the same key.
+func (s *SparseSet) graftContainer(key uint64, words *[1024]uint64, card int) error {
+	var sub subber = full{shift: 8}
+	if card < 1<<16 {
+		n := &node{shift: 8, count: card}
+		for i := 0; i < 256; i++ {
+			var leaf Set256
+			for j := range leaf.sets {
+				leaf.sets[j] = Set64(words[i*4+j])
+			}
+			if !leaf.Empty() {
+				n.bitset.Add(uint8(i))
+				n.subnodes = append(n.subnodes, subnode{index: uint8(i), sub: &leaf})
+			}
+		}
+		sub = compact(n)
+	}
+	if s.root == nil {
+		s.root = &node{shift: 16}
+		s.prefix = key << 16 &^ s.root.mask()
+	} else {
+		s.grow(key << 16)
+		s.growTo(16)
+	}
+	s.root.graft(key<<16, 16, sub)
+	return nil
+}
+
+// MarshalRoaring32 returns the portable 32-bit Roaring serialization of s.
+// It returns an error if s has an element that does not fit in 32 bits.
+func (s *Set) MarshalRoaring32() ([]byte, error) {
+	cs := s.containers()
+	if len(cs) > 0 && cs[len(cs)-1].key >= roaringMaxContainers {
+		return nil, errors.New("bit: Set has elements too large for a 32-bit Roaring bitmap")
+	}
+	return appendRoaring32(nil, cs), nil
+}
+
+// MarshalRoaring64 returns the portable 64-bit Roaring serialization of s.
+func (s *Set) MarshalRoaring64() ([]byte, error) {
+	return appendRoaring64(nil, s.containers()), nil
+}
+
+// UnmarshalRoaring32 replaces the contents of s with the elements of a
+// portable 32-bit Roaring bitmap. If s's capacity is too small for the
+// elements, UnmarshalRoaring32 increases it, but to no more than 2^24. It
+// returns an error for larger elements, unless s already has the capacity
+// for them. If data is invalid, UnmarshalRoaring32 returns an error and
+// leaves s unchanged.
+func (s *Set) UnmarshalRoaring32(data []byte) error {
+	return s.unmarshalRoaring(data, false)
+}
+
+// UnmarshalRoaring64 is like UnmarshalRoaring32, but for the 64-bit format.
+func (s *Set) UnmarshalRoaring64(data []byte) error {
+	return s.unmarshalRoaring(data, true)
+}
+
+func (s *Set) unmarshalRoaring(data []byte, is64 bool) error {
+	var t Set
+	err := parseRoaring(data, is64, func(key uint64, words *[1024]uint64, card int) error {
+		return t.setContainer(key, words, s)
+	})
+	if err != nil {
+		return err
+	}
+	// Keep the capacity of s, as UnmarshalJSON does.
+	s.Clear()
+	s.grow(len(t.sets))
+	copy(s.sets, t.sets)
+	return nil
+}
+
+// containers returns the Roaring containers of s, in order.
+func (s *Set) containers() []container {
+	var cs []container
+	for start := 0; start < len(s.sets); start += 1024 {
+		end := start + 1024
+		if end > len(s.sets) {
+			end = len(s.sets)
+		}
+		ws := s.sets[start:end]
+		card := 0
+		for _, w := range ws {
+			card += w.Size()
+		}
+		if card == 0 {
+			continue
+		}
+		cs = append(cs, container{
+			key:  uint64(start / 1024),
+			card: card,
+			fill: func(words *[1024]uint64) {
+				*words = [1024]uint64{}
+				for i, w := range ws {
+					words[i] = uint64(w)
+				}
+			},
+			n: 1,
+		})
+	}
+	return cs
+}
+
+// setContainer sets the words of s corresponding to a container, growing s
+// as needed, but not beyond dst.decodeLimit(). dst is the Set that s will
+// replace.
+func (s *Set) setContainer(key uint64, words *[1024]uint64, dst *Set) error {
+	last := 1023
+	for words[last] == 0 {
+		last--
+	}
+	if m := key<<16 | uint64(last*64+63-bits.LeadingZeros64(words[last])); m >= uint64(dst.decodeLimit()) {
+		return dst.decodeError(m)
+	}
+	start := int(key) * 1024
+	s.grow(start + last + 1)
+	for i, w := range words[:last+1] {
+		s.sets[start+i] = Set64(w)
+	}
+	return nil
+}
+
+// appendRoaring32 appends the 32-bit serialization of cs to b.
+// The keys of cs must fit in 16 bits. The containers are written without
+// run-length encoding, so any Roaring implementation can read them.
+func appendRoaring32(b []byte, cs []container) []byte {
+	start := len(b)
+	var count int
+	for _, c := range cs {
+		count += int(c.n)
+	}
+	b = appendUint32(b, roaringCookieNoRun)
+	b = appendUint32(b, uint32(count))
+	for _, c := range cs {
+		for k := range c.n {
+			b = appendUint16(b, uint16(c.key+k))
+			b = appendUint16(b, uint16(c.card-1))
+		}
+	}
+	offset := len(b) - start + 4*count
+	for _, c := range cs {
+		for range c.n {
+			b = appendUint32(b, uint32(offset))
+			if c.card <= roaringMaxArraySize {
+				offset += 2 * c.card
+			} else {
+				offset += 8 * 1024
+			}
+		}
+	}
+	var words [1024]uint64
+	for _, c := range cs {
+		c.fill(&words)
+		for range c.n {
+			if c.card <= roaringMaxArraySize {
+				for i, w := range words {
+					for w != 0 {
+						b = appendUint16(b, uint16(i*64+bits.TrailingZeros64(w)))
+						w &= w - 1
+					}
+				}
+			} else {
+				for _, w := range words {
+					b = appendUint64(b, w)
+				}
+			}
+		}
+	}
+	return b
+}
+
+// appendRoaring64 appends the 64-bit serialization of cs to b.
+func appendRoaring64(b []byte, cs []container) []byte {
+	// Group the containers by their high 32 bits, splitting runs that
+	// cross from one group to the next.
+	var groups [][]container
+	for _, c := range cs {
+		for c.n > 0 {
+			d := c
+			d.n = min(c.n, c.key|0xffff+1-c.key)
+			if len(groups) == 0 || d.key>>16 != groups[len(groups)-1][0].key>>16 {
+				groups = append(groups, nil)
+			}
+			groups[len(groups)-1] = append(groups[len(groups)-1], d)
+			c.key += d.n
+			c.n -= d.n
+		}
+	}
+	b = appendUint64(b, uint64(len(groups)))
+	for _, g := range groups {
+		b = appendUint32(b, uint32(g[0].key>>16))
+		b = appendRoaring32(b, g)
+	}
+	return b
+}
+
+func appendUint16(b []byte, u uint16) []byte {
+	return append(b, byte(u), byte(u>>8))
+}
+
+func appendUint32(b []byte, u uint32) []byte {
+	return append(b, byte(u), byte(u>>8), byte(u>>16), byte(u>>24))
+}
+
+func appendUint64(b []byte, u uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(u)), uint32(u>>32))
+}
+
+// parseRoaring parses data as a 64-bit Roaring bitmap if is64 is true, and as
+// a 32-bit one otherwise. It calls f with each container's key, bitmap and
+// cardinality, in increasing order of key. The words passed to f are reused
+// between calls. If f returns an error, parseRoaring stops and returns it.
+func parseRoaring(data []byte, is64 bool, f containerFunc) error {
+	d := &roaringDecoder{data: data}
+	if !is64 {
+		d.bitmap32(0, f)
+	} else {
+		n := d.uint64()
+		// Each bitmap takes at least 12 bytes.
+		if d.err == nil && n > uint64(len(d.data)/12) {
+			d.fail("too many bitmaps")
+		}
+		var prev uint64
+		for i := uint64(0); i < n && d.err == nil; i++ {
+			high := uint64(d.uint32())
+			if i > 0 && high <= prev {
+				d.fail("keys out of order")
+			}
+			prev = high
+			d.bitmap32(high<<16, f)
+		}
+	}
+	if d.err == nil && len(d.data) > 0 {
+		d.fail(fmt.Sprintf("%d bytes of extra data", len(d.data)))
+	}
+	return d.err
+}
+
+type containerFunc func(key uint64, words *[1024]uint64, card int) error
+
+// roaringDecoder consumes data from the front of a byte slice.
+// After the first error, its methods do nothing and return zero.
+type roaringDecoder struct {
+	data []byte
+	err  error
+}
+
+func (d *roaringDecoder) fail(msg string) {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: %s", errCorruptRoaring, msg)
+	}
+}
+
+func (d *roaringDecoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n > len(d.data) {
+		d.fail("unexpected end of data")
+		return nil
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *roaringDecoder) uint16() uint16 {
+	if b := d.next(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *roaringDecoder) uint32() uint32 {
+	if b := d.next(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *roaringDecoder) uint64() uint64 {
+	if b := d.next(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+// bitmap32 parses a 32-bit Roaring bitmap. It adds high to each container's
+// key before passing it to f.
+func (d *roaringDecoder) bitmap32(high uint64, f containerFunc) {
+	cookie := d.uint32()
+	var size int
+	var runs []byte // bitset of run containers
+	switch {
+	case cookie == roaringCookieNoRun:
+		n := d.uint32()
+		if n > roaringMaxContainers {
+			d.fail("too many containers")
+			return
+		}
+		size = int(n)
+	case cookie&0xffff == roaringCookie:
+		size = int(cookie>>16) + 1
+		runs = d.next((size + 7) / 8)
+	default:
+		d.fail("bad cookie")
+	}
+	if d.err != nil {
+		return
+	}
+	header := d.next(4 * size)
+	if runs == nil || size >= roaringNoOffsetThresh {
+		d.next(4 * size) // The offsets are redundant for sequential reading.
+	}
+	if d.err != nil {
+		return
+	}
+	var words [1024]uint64
+	for i := 0; i < size; i++ {
+		key := binary.LittleEndian.Uint16(header[4*i:])
+		card := int(binary.LittleEndian.Uint16(header[4*i+2:])) + 1
+		if i > 0 && key <= binary.LittleEndian.Uint16(header[4*(i-1):]) {
+			d.fail("keys out of order")
+			return
+		}
+		words = [1024]uint64{}
+		switch {
+		case runs != nil && runs[i/8]&(1<<uint(i%8)) != 0:
+			d.runContainer(&words, card)
+		case card <= roaringMaxArraySize:
+			d.arrayContainer(&words, card)
+		default:
+			d.bitmapContainer(&words, card)
+		}
+		if d.err != nil {
+			return
+		}
+		if err := f(high|uint64(key), &words, card); err != nil {
+			d.err = err
+			return
+		}
+	}
+}
+
+func (d *roaringDecoder) arrayContainer(words *[1024]uint64, card int) {
+	b := d.next(2 * card)
+	if b == nil {
+		return
+	}
+	for i := 0; i < card; i++ {
+		v := binary.LittleEndian.Uint16(b[2*i:])
+		if i > 0 && v <= binary.LittleEndian.Uint16(b[2*(i-1):]) {
+			d.fail("array container out of order")
+			return
+		}
+		words[v/64] |= 1 << (v % 64)
+	}
+}
+
+func (d *roaringDecoder) bitmapContainer(words *[1024]uint64, card int) {
+	b := d.next(8 * 1024)
+	if b == nil {
+		return
+	}
+	n := 0
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint64(b[8*i:])
+		n += bits.OnesCount64(words[i])
+	}
+	if n != card {
+		d.fail("wrong bitmap container cardinality")
+	}
+}
+
+func (d *roaringDecoder) runContainer(words *[1024]uint64, card int) {
+	nruns := int(d.uint16())
+	b := d.next(4 * nruns)
+	if b == nil {
+		return
+	}
+	n := 0
+	next := 0 // the smallest value the next run may start at
+	for i := 0; i < nruns; i++ {
+		start := int(binary.LittleEndian.Uint16(b[4*i:]))
+		end := start + int(binary.LittleEndian.Uint16(b[4*i+2:])) // inclusive
+		if start < next || end > 0xffff {
+			d.fail("bad run")
+			return
+		}
+		for v := start; v <= end; v++ {
+			words[v/64] |= 1 << uint(v%64)
+		}
+		n += end - start + 1
+		next = end + 1
+	}
+	if n != card {
+		d.fail("wrong run container cardinality")
+	}
+}
@@ -0,0 +1,301 @@
+package bit
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// fromHex decodes a hex string, ignoring spaces and newlines.
+func fromHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// Golden 32-bit Roaring bitmaps, constructed by hand from the format spec.
+var roaring32Golden = []struct {
+	name string
+	hex  string
+	els  []uint64
+	// canonical is true if this package produces exactly these bytes.
+	canonical bool
+}{
+	{
+		name:      "empty",
+		hex:       `3a300000 00000000`, // cookie 12346, 0 containers
+		els:       nil,
+		canonical: true,
+	},
+	{
+		name: "two arrays",
+		hex: `3a300000 02000000` + // cookie 12346, 2 containers
+			`0000 0100  0100 0000` + // key 0, card 2; key 1, card 1
+			`18000000 1c000000` + // offsets 24, 28
+			`0100 0200` + // 1, 2
+			`0500`, // 65536+5
+		els:       []uint64{1, 2, 65536 + 5},
+		canonical: true,
+	},
+	{
+		name: "run",
+		hex: `3b300000` + // cookie 12347, 1 container
+			`01` + // container 0 is a run container
+			`0000 0500` + // key 0, card 6
+			`0200 0a00 0400 ffff 0000`, // 2 runs: [10, 14], [65535, 65535]
+		els: []uint64{10, 11, 12, 13, 14, 65535},
+	},
+	{
+		name: "runs with offsets",
+		hex: `3b300300` + // cookie 12347, 4 containers
+			`05` + // containers 0 and 2 are run containers
+			`0000 0000  0100 0000  0200 0100  0300 0000` + // keys 0-3
+			`00000000 00000000 00000000 00000000` + // offsets (ignored)
+			`0100 0700 0000` + // 1 run: [7, 7]
+			`0800` + // array: 8
+			`0100 0900 0100` + // 1 run: [9, 10]
+			`ffff`, // array: 65535
+		els: []uint64{7, 1<<16 + 8, 2<<16 + 9, 2<<16 + 10, 3<<16 + 65535},
+	},
+}
+
+func TestRoaring32Golden(t *testing.T) {
+	for _, test := range roaring32Golden {
+		data := fromHex(t, test.hex)
+		var s SparseSet
+		if err := s.UnmarshalRoaring32(data); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if want := NewSparseSet(test.els...); !s.Equal(want) {
+			t.Errorf("%s: got %s, want %s", test.name, s, want)
+		}
+		var d Set
+		if err := d.UnmarshalRoaring32(data); err != nil {
+			t.Fatalf("%s: Set: %v", test.name, err)
+		}
+		var got []uint64
+		for it := d.Iterator(); ; {
+			e, ok := it.Next()
+			if !ok {
+				break
+			}
+			got = append(got, e)
+		}
+		if !cmp.Equal(got, test.els) {
+			t.Errorf("%s: Set: got %v, want %v", test.name, got, test.els)
+		}
+		if test.canonical {
+			for _, m := range []func() ([]byte, error){s.MarshalRoaring32, d.MarshalRoaring32} {
+				b, err := m()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !cmp.Equal(b, data) {
+					t.Errorf("%s: marshal:\ngot  %x\nwant %x", test.name, b, data)
+				}
+			}
+		}
+	}
+}
+
+func TestRoaringBitmapContainer(t *testing.T) {
+	// The elements [0, 4096] need a bitmap container.
+	want := fromHex(t, `3a300000 01000000 0000 0010 10000000`)
+	for i := 0; i < 64; i++ {
+		want = append(want, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+	}
+	want = append(want, 1, 0, 0, 0, 0, 0, 0, 0)
+	want = append(want, make([]byte, 8*(1024-65))...)
+
+	var s SparseSet
+	d := NewSet(5000)
+	for i := 0; i <= 4096; i++ {
+		s.Add(uint64(i))
+		d.Add(i)
+	}
+	for _, m := range []func() ([]byte, error){s.MarshalRoaring32, d.MarshalRoaring32} {
+		got, err := m()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !cmp.Equal(got, want) {
+			t.Fatalf("got %x...", got[:32])
+		}
+	}
+	var s2 SparseSet
+	if err := s2.UnmarshalRoaring32(want); err != nil {
+		t.Fatal(err)
+	}
+	if !s2.Equal(&s) {
+		t.Error("round trip failed")
+	}
+}
+
+func TestRoaring64Golden(t *testing.T) {
+	data := fromHex(t, `0200000000000000`+ // 2 bitmaps
+		`00000000`+ // high bits 0
+		`3a300000 01000000 0000 0000 10000000 0300`+ // {3}
+		`01000000`+ // high bits 1
+		`3a300000 02000000 0000 0000 0100 0000 18000000 1a000000 0000 0200`) // {0, 65536+2}
+	els := []uint64{3, 1 << 32, 1<<32 + 1<<16 + 2}
+	var s SparseSet
+	if err := s.UnmarshalRoaring64(data); err != nil {
+		t.Fatal(err)
+	}
+	if want := NewSparseSet(els...); !s.Equal(want) {
+		t.Errorf("got %s, want %s", s, want)
+	}
+	got, err := s.MarshalRoaring64()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(got, data) {
+		t.Errorf("marshal:\ngot  %x\nwant %x", got, data)
+	}
+	if _, err := s.MarshalRoaring32(); err == nil {
+		t.Error("MarshalRoaring32 of large elements succeeded")
+	}
+}
+
+func TestRoaringFullRanges(t *testing.T) {
+	// A huge full range is rejected before any container is built.
+	var s SparseSet
+	s.AddRange(0, 1<<40)
+	if _, err := s.MarshalRoaring32(); err == nil {
+		t.Error("MarshalRoaring32 of a huge range succeeded")
+	}
+
+	// Full ranges are written as one container per 2^16 elements, and a
+	// range that crosses a 32-bit boundary is split between two bitmaps.
+	for _, r := range [][2]uint64{{0, 1<<20 - 1}, {5, 1 << 18}, {1<<32 - 1<<17, 1<<32 + 1<<17}} {
+		var s SparseSet
+		s.AddRange(r[0], r[1])
+		marshals := []func() ([]byte, error){s.MarshalRoaring64}
+		unmarshals := []func(*SparseSet, []byte) error{(*SparseSet).UnmarshalRoaring64}
+		if r[1] < 1<<32 {
+			marshals = append(marshals, s.MarshalRoaring32)
+			unmarshals = append(unmarshals, (*SparseSet).UnmarshalRoaring32)
+		}
+		for i, m := range marshals {
+			data, err := m()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got SparseSet
+			if err := unmarshals[i](&got, data); err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(&s) {
+				t.Errorf("range %#x: got %s, want %s", r, &got, &s)
+			}
+		}
+	}
+}
+
+func TestRoaringRoundTrip(t *testing.T) {
+	s := randSparseSet(2000)
+	for i := uint64(0); i < 5000; i++ {
+		s.Add(1<<40 + i) // a bitmap container
+	}
+	data, err := s.MarshalRoaring64()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got SparseSet
+	if err := got.UnmarshalRoaring64(data); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(s) || got.Size() != s.Size() {
+		t.Error("round trip failed")
+	}
+}
+
+func TestRoaringCorrupt(t *testing.T) {
+	for _, h := range []string{
+		``,
+		`3a30`,
+		`39300000 00000000`, // bad cookie
+		`3a300000 01000000`, // missing header
+		`3a300000 01000000 0000 0100 10000000 0100`,                         // short array
+		`3a300000 01000000 0000 0100 10000000 0200 0100`,                    // unsorted array
+		`3a300000 02000000 0100 0000 0000 0000 18000000 1a000000 0100 0100`, // unsorted keys
+		`3b300000 01 0000 0100 0100 0000 0000`,                              // run cardinality mismatch
+		`3b300000 01 0000 0100 0100 ffff 0100`,                              // run too long
+		`3b300000 01 0000 0300 0200 0000 0100 0100 0100`,                    // overlapping runs
+		`3a300000 00000000 00`,                                              // extra data
+	} {
+		data := fromHex(t, h)
+		var s SparseSet
+		if err := s.UnmarshalRoaring32(data); err == nil {
+			t.Errorf("%s: got nil error", h)
+		}
+		var d Set
+		if err := d.UnmarshalRoaring32(data); err == nil {
+			t.Errorf("%s: Set: got nil error", h)
+		}
+	}
+	var s SparseSet
+	if err := s.UnmarshalRoaring64(fromHex(t, `ffffffff ffffffff`)); err == nil {
+		t.Error("64-bit: got nil error")
+	}
+}
+
+func TestRoaringSetLimit(t *testing.T) {
+	// Decoding grows a Set only so far, unless it already has the capacity.
+	for _, e := range []uint64{1 << 24, 1<<32 - 1, 1 << 40} {
+		data, err := NewSparseSet(3, e).MarshalRoaring64()
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := NewSetOf(64, 5)
+		if err := d.UnmarshalRoaring64(data); err == nil || !d.Contains(5) {
+			t.Errorf("%d: got %v, want error and unchanged set", e, err)
+		}
+		if e < 1<<32 {
+			data32, err := NewSparseSet(3, e).MarshalRoaring32()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := d.UnmarshalRoaring32(data32); err == nil {
+				t.Errorf("32-bit %d: got nil error", e)
+			}
+		}
+	}
+	data, err := NewSparseSet(3, 1<<24).MarshalRoaring64()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewSet(1<<24 + 1)
+	if err := d.UnmarshalRoaring64(data); err != nil || !d.Contains(1<<24) || !d.Contains(3) {
+		t.Errorf("with capacity: %v", err)
+	}
+	data, err = NewSparseSet(3, 1<<24-1).MarshalRoaring64()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var z Set
+	if err := z.UnmarshalRoaring64(data); err != nil || !z.Contains(1<<24-1) {
+		t.Errorf("largest element: %v", err)
+	}
+
+	// Decoding keeps the capacity of the Set, as JSON decoding does.
+	data, err = NewSparseSet(1).MarshalRoaring32()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, j := NewSetOf(1000, 900), NewSetOf(1000, 900)
+	if err := d.UnmarshalRoaring32(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.UnmarshalJSON([]byte(`[1]`)); err != nil {
+		t.Fatal(err)
+	}
+	if !d.Equal(j) || d.Capacity() != j.Capacity() || d.Capacity() != 1024 {
+		t.Errorf("got %s with capacity %d, want %s with capacity %d", d, d.Capacity(), j, j.Capacity())
+	}
+}
@@ -0,0 +1,149 @@
+package bit
+
+import (
+	"iter"
+	"math/bits"
+)
+
+// The functions in this file return iterators for use with range-over-func.
+// Like the iterators in iterator.go, they do not allocate a buffer for the
+// elements, and their behavior is undefined if the set is modified during
+// iteration.
+
+// All returns an iterator over the elements of s, in increasing order.
+func (s *Set256) All() iter.Seq[uint8] {
+	return s.From(0)
+}
+
+// From returns an iterator over the elements of s that are at least start,
+// in increasing order.
+func (s *Set256) From(start uint8) iter.Seq[uint8] {
+	return func(yield func(uint8) bool) {
+		for i := start / 64; i < 4; i++ {
+			w := uint64(s.sets[i])
+			if i == start/64 {
+				w = w >> (start % 64) << (start % 64)
+			}
+			for w != 0 {
+				if !yield(i*64 + uint8(bits.TrailingZeros64(w))) {
+					return
+				}
+				w &= w - 1 // clear the lowest bit
+			}
+		}
+	}
+}
+
+// Backward returns an iterator over the elements of s, in decreasing order.
+func (s *Set256) Backward() iter.Seq[uint8] {
+	return func(yield func(uint8) bool) {
+		for i := 3; i >= 0; i-- {
+			w := uint64(s.sets[i])
+			for w != 0 {
+				b := 63 - bits.LeadingZeros64(w)
+				if !yield(uint8(i*64 + b)) {
+					return
+				}
+				w &^= 1 << b
+			}
+		}
+	}
+}
+
+// All returns an iterator over the elements of s, in increasing order.
+func (s *Set) All() iter.Seq[int] {
+	return s.From(0)
+}
+
+// From returns an iterator over the elements of s that are at least start,
+// in increasing order.
+func (s *Set) From(start int) iter.Seq[int] {
+	return func(yield func(int) bool) {
+		it := s.Iterator()
+		if start > 0 {
+			it.Seek(uint64(start))
+		}
+		for e, ok := it.Next(); ok; e, ok = it.Next() {
+			if !yield(int(e)) {
+				return
+			}
+		}
+	}
+}
+
+// Backward returns an iterator over the elements of s, in decreasing order.
+func (s *Set) Backward() iter.Seq[int] {
+	return func(yield func(int) bool) {
+		for i := len(s.sets) - 1; i >= 0; i-- {
+			w := uint64(s.sets[i])
+			for w != 0 {
+				b := 63 - bits.LeadingZeros64(w)
+				if !yield(i*64 + b) {
+					return
+				}
+				w &^= 1 << b
+			}
+		}
+	}
+}
+
+// All returns an iterator over the elements of s, in increasing order.
+func (s *SparseSet) All() iter.Seq[uint64] {
+	return s.From(0)
+}
+
+// From returns an iterator over the elements of s that are at least start,
+// in increasing order.
+func (s *SparseSet) From(start uint64) iter.Seq[uint64] {
+	return func(yield func(uint64) bool) {
+		it := &SparseSetIterator{s: *s}
+		it.Seek(start)
+		for e, ok := it.Next(); ok; e, ok = it.Next() {
+			if !yield(e) {
+				return
+			}
+		}
+	}
+}
+
+// Backward returns an iterator over the elements of s, in decreasing order.
+func (s *SparseSet) Backward() iter.Seq[uint64] {
+	return func(yield func(uint64) bool) {
+		if s.root != nil {
+			backward(s.root, s.prefix, yield)
+		}
+	}
+}
+
+// backward calls yield on the elements of sub in decreasing order, until
+// yield returns false. The high bits of the elements of sub are high.
+// backward reports whether it reached the end of sub.
+func backward(sub subber, high uint64, yield func(uint64) bool) bool {
+	switch sub := sub.(type) {
+	case *Set256:
+		for e := range sub.Backward() {
+			if !yield(high | uint64(e)) {
+				return false
+			}
+		}
+	case full:
+		for e := high | sub.mask(); ; e-- {
+			if !yield(e) {
+				return false
+			}
+			if e == high {
+				break
+			}
+		}
+	case *chain:
+		return backward(sub.sub, high|sub.prefix, yield)
+	case *node:
+		for i := len(sub.subnodes) - 1; i >= 0; i-- {
+			sn := sub.subnodes[i]
+			if !backward(sn.sub, high|uint64(sn.index)<<sub.shift, yield) {
+				return false
+			}
+		}
+	}
+	return true
+}
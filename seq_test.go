@@ -0,0 +1,108 @@
+package bit
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestSet256Seq(t *testing.T) {
+	s := sampleSet256()
+	var want []uint8
+	for _, e := range naiveElementsUint64(&s) {
+		want = append(want, uint8(e))
+	}
+	if got := slices.Collect(s.All()); !cmp.Equal(got, want) {
+		t.Errorf("All: got %v, want %v", got, want)
+	}
+	if got, want := slices.Collect(s.From(65)), []uint8{70, 192, 200, 201}; !cmp.Equal(got, want) {
+		t.Errorf("From: got %v, want %v", got, want)
+	}
+	slices.Reverse(want)
+	if got := slices.Collect(s.Backward()); !cmp.Equal(got, want) {
+		t.Errorf("Backward: got %v, want %v", got, want)
+	}
+}
+
+func TestSetSeq(t *testing.T) {
+	want := []int{0, 63, 64, 200, 299}
+	s := newSet(300, want...)
+	if got := slices.Collect(s.All()); !cmp.Equal(got, want) {
+		t.Errorf("All: got %v, want %v", got, want)
+	}
+	if got := slices.Collect(s.From(64)); !cmp.Equal(got, want[2:]) {
+		t.Errorf("From: got %v, want %v", got, want[2:])
+	}
+	if got := slices.Collect(s.From(-5)); !cmp.Equal(got, want) {
+		t.Errorf("From(-5): got %v, want %v", got, want)
+	}
+	slices.Reverse(want)
+	if got := slices.Collect(s.Backward()); !cmp.Equal(got, want) {
+		t.Errorf("Backward: got %v, want %v", got, want)
+	}
+}
+
+func TestSparseSetSeq(t *testing.T) {
+	s := randSparseSet(1000)
+	s.AddRange(1<<20, 1<<20+3*65536) // includes full subtrees
+	want := make([]uint64, s.Size())
+	s.Elements(want, 0)
+	if got := slices.Collect(s.All()); !cmp.Equal(got, want) {
+		t.Error("All and Elements differ")
+	}
+	start := want[len(want)/2] + 1
+	a := make([]uint64, len(want))
+	n := s.Elements(a, start)
+	if got := slices.Collect(s.From(start)); !cmp.Equal(got, a[:n]) {
+		t.Error("From and Elements differ")
+	}
+	slices.Reverse(want)
+	if got := slices.Collect(s.Backward()); !cmp.Equal(got, want) {
+		t.Error("Backward and Elements differ")
+	}
+
+	var empty SparseSet
+	if got := slices.Collect(empty.Backward()); !cmp.Equal(got, []uint64(nil), cmpopts.EquateEmpty()) {
+		t.Errorf("empty: got %v", got)
+	}
+}
+
+func TestSeqBreak(t *testing.T) {
+	// Each iterator must stop when the loop body breaks; if it called yield
+	// again, the range statement would panic.
+	s := NewSparseSet(1, 2, 1<<40, 1<<50)
+	s.AddRange(1000, 200000)
+	for _, seq := range []func() []uint64{
+		func() []uint64 { return takeSeq(s.All(), 3) },
+		func() []uint64 { return takeSeq(s.Backward(), 3) },
+		func() []uint64 { return takeSeq(s.From(199999), 3) },
+	} {
+		if got := seq(); len(got) != 3 {
+			t.Errorf("got %v, want 3 elements", got)
+		}
+	}
+	n := 0
+	for range newSet(200, 1, 2, 3, 150).Backward() {
+		n++
+		if n == 2 {
+			break
+		}
+	}
+	s256 := sampleSet256()
+	for range s256.All() {
+		break
+	}
+}
+
+func takeSeq(seq func(func(uint64) bool), n int) []uint64 {
+	var els []uint64
+	for e := range seq {
+		els = append(els, e)
+		if len(els) == n {
+			break
+		}
+	}
+	return els
+}
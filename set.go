@@ -4,6 +4,8 @@ package bit
 // Set is a standard bitset, represented "densely"; in other words,
 // using one bit per element. See SparseSet in this package for
 // a more compact storage scheme for sparse bitsets.
+// A Set is not safe for concurrent use if any goroutine modifies it;
+// see AtomicSet.
 type Set struct {
 	sets []Set64
 }
@@ -18,6 +20,16 @@ func NewSet(capacity int) *Set {
 	}
 }
 
+// NewSetOf creates a set with the given capacity, as NewSet does, and adds
+// els to it. It panics if an element is out of range.
+func NewSetOf(capacity int, els ...int) *Set {
+	s := NewSet(capacity)
+	for _, e := range els {
+		s.Add(e)
+	}
+	return s
+}
+
 func setslice(capacity int) []Set64 {
 	if capacity == 0 {
 		return nil
@@ -32,6 +44,15 @@ func (s *Set) Capacity() int {
 	return len(s.sets) * 64
 }
 
+func (s *Set) Empty() bool {
+	for _, t := range s.sets {
+		if !t.Empty() {
+			return false
+		}
+	}
+	return true
+}
+
 func (s *Set) Size() int {
 	sz := 0
 	for _, t := range s.sets {
@@ -55,7 +76,155 @@ func (s *Set) Remove(i int) {
 // TODO: arg should be uint
 func (s *Set) Contains(i int) bool {
 	u := uint(i)
-	return s.sets[u/64].Contains(uint8(u ^ 64))
+	return s.sets[u/64].Contains(uint8(u % 64))
+}
+
+// The range methods operate on the elements in the closed interval [lo, hi].
+// If lo > hi, the interval is empty. AddRange, RemoveRange and FlipRange
+// panic if the interval extends beyond [0, s.Capacity()).
+
+// AddRange adds the elements in [lo, hi] to s.
+func (s *Set) AddRange(lo, hi int) {
+	s.rangeOp(lo, hi, func(w *Set64, m Set64) { *w |= m })
+}
+
+// RemoveRange removes the elements in [lo, hi] from s.
+func (s *Set) RemoveRange(lo, hi int) {
+	s.rangeOp(lo, hi, func(w *Set64, m Set64) { *w &^= m })
+}
+
+// FlipRange adds the elements in [lo, hi] that are not in s to s, and
+// removes those that are.
+func (s *Set) FlipRange(lo, hi int) {
+	s.rangeOp(lo, hi, func(w *Set64, m Set64) { *w ^= m })
+}
+
+// ContainsAll reports whether every element in [lo, hi] is in s.
+func (s *Set) ContainsAll(lo, hi int) bool {
+	if lo > hi {
+		return true
+	}
+	if lo < 0 || hi >= s.Capacity() {
+		return false
+	}
+	all := true
+	s.rangeOp(lo, hi, func(w *Set64, m Set64) { all = all && *w&m == m })
+	return all
+}
+
+// Count returns the number of elements of s in [lo, hi].
+func (s *Set) Count(lo, hi int) int {
+	if lo < 0 {
+		lo = 0
+	}
+	if c := s.Capacity(); hi >= c {
+		hi = c - 1
+	}
+	n := 0
+	s.rangeOp(lo, hi, func(w *Set64, m Set64) { n += (*w & m).Size() })
+	return n
+}
+
+// rangeOp calls f on each word of s that overlaps [lo, hi], with a mask of
+// the bits of the word in the interval.
+func (s *Set) rangeOp(lo, hi int, f func(w *Set64, mask Set64)) {
+	if lo > hi {
+		return
+	}
+	if lo < 0 || hi >= s.Capacity() {
+		panic("range out of bounds")
+	}
+	for i := lo / 64; i <= hi/64; i++ {
+		var wlo, whi uint8 = 0, 63
+		if i == lo/64 {
+			wlo = uint8(lo % 64)
+		}
+		if i == hi/64 {
+			whi = uint8(hi % 64)
+		}
+		f(&s.sets[i], rangeMask(wlo, whi))
+	}
+}
+
+// Rank returns the number of elements of s that are less than i.
+func (s *Set) Rank(i int) int {
+	if i <= 0 {
+		return 0
+	}
+	u := uint(i)
+	if u >= uint(s.Capacity()) {
+		return s.Size()
+	}
+	r := 0
+	for _, t := range s.sets[:u/64] {
+		r += t.Size()
+	}
+	return r + s.sets[u/64].Rank(uint8(u%64))
+}
+
+// Select returns the element of s whose rank is k; that is, the k'th smallest
+// element, counting from zero. The second return value is false if k is
+// out of range.
+func (s *Set) Select(k int) (int, bool) {
+	if k < 0 {
+		return 0, false
+	}
+	for i, t := range s.sets {
+		sz := t.Size()
+		if k < sz {
+			e, _ := t.Select(k)
+			return i*64 + int(e), true
+		}
+		k -= sz
+	}
+	return 0, false
+}
+
+// Min returns the smallest element of s and true, or 0 and false if s is empty.
+func (s *Set) Min() (int, bool) {
+	e, ok := s.next(0)
+	return int(e), ok
+}
+
+// Max returns the largest element of s and true, or 0 and false if s is empty.
+func (s *Set) Max() (int, bool) {
+	e, ok := s.prev(^uint64(0))
+	return int(e), ok
+}
+
+// NextAfter returns the smallest element of s that is greater than i, and
+// true. The second return value is false if there is no such element.
+func (s *Set) NextAfter(i int) (int, bool) {
+	if i < 0 {
+		return s.Min()
+	}
+	e, ok := s.next(uint64(i) + 1)
+	return int(e), ok
+}
+
+// PrevBefore returns the largest element of s that is less than i, and
+// true. The second return value is false if there is no such element.
+// To find the largest element that is at most i, use PrevBefore(i+1).
+func (s *Set) PrevBefore(i int) (int, bool) {
+	if i <= 0 {
+		return 0, false
+	}
+	e, ok := s.prev(uint64(i) - 1)
+	return int(e), ok
+}
+
+// Each calls f on each element of s in increasing order, until f returns false.
+func (s *Set) Each(f func(int) bool) {
+	for i, t := range s.sets {
+		more := true
+		t.Each(func(u uint8) bool {
+			more = f(i*64 + int(u))
+			return more
+		})
+		if !more {
+			return
+		}
+	}
 }
 
 func (s *Set) ChangeCapacity(newCapacity int) {
@@ -70,18 +239,175 @@ func (s *Set) Clear() {
 	}
 }
 
-// TODO: UnionWith
+// Binary operations work on sets of different capacities. The in-place
+// operations UnionWith and SymmetricDifferenceWith grow the capacity of the
+// receiver to that of the argument if the argument's is larger; IntersectWith
+// and DifferenceWith never change the receiver's capacity. The capacity of
+// the result of Union and SymmetricDifference is the largest capacity of their
+// arguments, and that of Intersect and Difference is the capacity of their
+// first argument.
+
+func (s1 *Set) UnionWith(s2 *Set) {
+	s1.grow(len(s2.sets))
+	for i, t := range s2.sets {
+		s1.sets[i].UnionWith(t)
+	}
+}
 
 func (s1 *Set) IntersectWith(s2 *Set) {
 	min := len(s1.sets)
 	if min > len(s2.sets) {
 		min = len(s2.sets)
 	}
-	m := min / 64
-	for i := 0; i < m; i++ {
+	for i := 0; i < min; i++ {
 		s1.sets[i].IntersectWith(s2.sets[i])
 	}
-	for i := m; i < len(s1.sets); i++ {
+	for i := min; i < len(s1.sets); i++ {
 		s1.sets[i].Clear()
 	}
 }
+
+// DifferenceWith removes the elements of s2 from s1.
+func (s1 *Set) DifferenceWith(s2 *Set) {
+	min := len(s1.sets)
+	if min > len(s2.sets) {
+		min = len(s2.sets)
+	}
+	for i := 0; i < min; i++ {
+		s1.sets[i].DifferenceWith(s2.sets[i])
+	}
+}
+
+// SymmetricDifferenceWith sets s1 to the elements that are in exactly one of
+// s1 and s2.
+func (s1 *Set) SymmetricDifferenceWith(s2 *Set) {
+	s1.grow(len(s2.sets))
+	for i, t := range s2.sets {
+		s1.sets[i].SymmetricDifferenceWith(t)
+	}
+}
+
+// grow makes sure s has at least n words.
+func (s *Set) grow(n int) {
+	if n > len(s.sets) {
+		newSets := make([]Set64, n)
+		copy(newSets, s.sets)
+		s.sets = newSets
+	}
+}
+
+// Union sets s to the union of the ss.
+// s may be one of the ss.
+func (s *Set) Union(ss ...*Set) {
+	r := &Set{}
+	for _, t := range ss {
+		r.UnionWith(t)
+	}
+	s.sets = r.sets
+}
+
+// Intersect sets s to the intersection of the ss.
+// s may be one of the ss.
+func (s *Set) Intersect(ss ...*Set) {
+	if len(ss) == 0 {
+		s.sets = nil
+		return
+	}
+	r := ss[0].Copy()
+	for _, t := range ss[1:] {
+		r.IntersectWith(t)
+	}
+	s.sets = r.sets
+}
+
+// Difference sets s to the elements of ss[0] that are in none of ss[1:].
+// s may be one of the ss.
+func (s *Set) Difference(ss ...*Set) {
+	if len(ss) == 0 {
+		s.sets = nil
+		return
+	}
+	r := ss[0].Copy()
+	for _, t := range ss[1:] {
+		r.DifferenceWith(t)
+	}
+	s.sets = r.sets
+}
+
+// SymmetricDifference sets s to the elements that are in an odd number of the
+// ss. s may be one of the ss.
+func (s *Set) SymmetricDifference(ss ...*Set) {
+	r := &Set{}
+	for _, t := range ss {
+		r.SymmetricDifferenceWith(t)
+	}
+	s.sets = r.sets
+}
+
+// Equal reports whether s1 and s2 have the same elements.
+// Their capacities may differ.
+func (s1 *Set) Equal(s2 *Set) bool {
+	a, b := s1.sets, s2.sets
+	if len(a) < len(b) {
+		a, b = b, a
+	}
+	for i, t := range b {
+		if a[i] != t {
+			return false
+		}
+	}
+	for _, t := range a[len(b):] {
+		if !t.Empty() {
+			return false
+		}
+	}
+	return true
+}
+
+// IsSubsetOf reports whether every element of s1 is in s2.
+// Their capacities may differ.
+func (s1 *Set) IsSubsetOf(s2 *Set) bool {
+	for i, t := range s1.sets {
+		if i >= len(s2.sets) {
+			if !t.Empty() {
+				return false
+			}
+		} else if !t.IsSubsetOf(s2.sets[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsSupersetOf reports whether every element of s2 is in s1.
+// Their capacities may differ.
+func (s1 *Set) IsSupersetOf(s2 *Set) bool { return s2.IsSubsetOf(s1) }
+
+// Intersects reports whether s1 and s2 have an element in common.
+// Their capacities may differ.
+func (s1 *Set) Intersects(s2 *Set) bool {
+	for i := 0; i < len(s1.sets) && i < len(s2.sets); i++ {
+		if s1.sets[i].Intersects(s2.sets[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsDisjoint reports whether s1 and s2 have no elements in common.
+// Their capacities may differ.
+func (s1 *Set) IsDisjoint(s2 *Set) bool { return !s1.Intersects(s2) }
+
+// Copy returns a copy of s with the same capacity.
+func (s *Set) Copy() *Set {
+	c := &Set{sets: make([]Set64, len(s.sets))}
+	copy(c.sets, s.sets)
+	return c
+}
+
+// Complement replaces s with its complement with respect to [0, s.Capacity()).
+func (s *Set) Complement() {
+	for i := range s.sets {
+		s.sets[i] = ^s.sets[i]
+	}
+}
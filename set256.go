@@ -1,8 +1,8 @@
 package bit
 
 import (
-	"bytes"
 	"fmt"
+	"math/bits"
 )
 
 // A Set256 represents a set of integers in the range [0, 256).
@@ -13,6 +13,15 @@ type Set256 struct {
 	sets [4]Set64
 }
 
+// NewSet256 returns a Set256 with the elements of els.
+func NewSet256(els ...uint8) Set256 {
+	var s Set256
+	for _, e := range els {
+		s.Add(e)
+	}
+	return s
+}
+
 func (s *Set256) Add(n uint8) {
 	s.sets[n/64].Add(n % 64)
 }
@@ -51,6 +60,79 @@ func (s1 *Set256) Equal(s2 *Set256) bool {
 		s1.sets[3] == s2.sets[3]
 }
 
+// IsSubsetOf reports whether every element of s1 is in s2.
+func (s1 *Set256) IsSubsetOf(s2 *Set256) bool {
+	return s1.sets[0].IsSubsetOf(s2.sets[0]) &&
+		s1.sets[1].IsSubsetOf(s2.sets[1]) &&
+		s1.sets[2].IsSubsetOf(s2.sets[2]) &&
+		s1.sets[3].IsSubsetOf(s2.sets[3])
+}
+
+// IsSupersetOf reports whether every element of s2 is in s1.
+func (s1 *Set256) IsSupersetOf(s2 *Set256) bool { return s2.IsSubsetOf(s1) }
+
+// Intersects reports whether s1 and s2 have an element in common.
+func (s1 *Set256) Intersects(s2 *Set256) bool {
+	return s1.sets[0].Intersects(s2.sets[0]) ||
+		s1.sets[1].Intersects(s2.sets[1]) ||
+		s1.sets[2].Intersects(s2.sets[2]) ||
+		s1.sets[3].Intersects(s2.sets[3])
+}
+
+// IsDisjoint reports whether s1 and s2 have no elements in common.
+func (s1 *Set256) IsDisjoint(s2 *Set256) bool { return !s1.Intersects(s2) }
+
+// The range methods operate on the elements in the closed interval [lo, hi].
+// If lo > hi, the interval is empty.
+
+// AddRange adds the elements in [lo, hi] to s.
+func (s *Set256) AddRange(lo, hi uint8) {
+	s.rangeOp(lo, hi, func(w *Set64, m Set64) { *w |= m })
+}
+
+// RemoveRange removes the elements in [lo, hi] from s.
+func (s *Set256) RemoveRange(lo, hi uint8) {
+	s.rangeOp(lo, hi, func(w *Set64, m Set64) { *w &^= m })
+}
+
+// FlipRange adds the elements in [lo, hi] that are not in s to s, and
+// removes those that are.
+func (s *Set256) FlipRange(lo, hi uint8) {
+	s.rangeOp(lo, hi, func(w *Set64, m Set64) { *w ^= m })
+}
+
+// ContainsAll reports whether every element in [lo, hi] is in s.
+func (s *Set256) ContainsAll(lo, hi uint8) bool {
+	all := true
+	s.rangeOp(lo, hi, func(w *Set64, m Set64) { all = all && *w&m == m })
+	return all
+}
+
+// Count returns the number of elements of s in [lo, hi].
+func (s *Set256) Count(lo, hi uint8) int {
+	n := 0
+	s.rangeOp(lo, hi, func(w *Set64, m Set64) { n += (*w & m).Size() })
+	return n
+}
+
+// rangeOp calls f on each word of s that overlaps [lo, hi], with a mask of
+// the bits of the word in the interval.
+func (s *Set256) rangeOp(lo, hi uint8, f func(w *Set64, mask Set64)) {
+	if lo > hi {
+		return
+	}
+	for i := lo / 64; i <= hi/64; i++ {
+		var wlo, whi uint8 = 0, 63
+		if i == lo/64 {
+			wlo = lo % 64
+		}
+		if i == hi/64 {
+			whi = hi % 64
+		}
+		f(&s.sets[i], rangeMask(wlo, whi))
+	}
+}
+
 // Position returns the 0-based position of n in the set. If
 // the set is {3, 8, 15}, then the position of 8 is 1.
 // If n is not in the set, returns 0, false.
@@ -71,6 +153,89 @@ func (b *Set256) Position(n uint8) (int, bool) {
 	return pos + p, ok
 }
 
+func (s1 *Set256) IntersectWith(s2 *Set256) {
+	s1.sets[0].IntersectWith(s2.sets[0])
+	s1.sets[1].IntersectWith(s2.sets[1])
+	s1.sets[2].IntersectWith(s2.sets[2])
+	s1.sets[3].IntersectWith(s2.sets[3])
+}
+
+func (s1 *Set256) UnionWith(s2 *Set256) {
+	s1.sets[0].UnionWith(s2.sets[0])
+	s1.sets[1].UnionWith(s2.sets[1])
+	s1.sets[2].UnionWith(s2.sets[2])
+	s1.sets[3].UnionWith(s2.sets[3])
+}
+
+// DifferenceWith removes the elements of s2 from s1.
+func (s1 *Set256) DifferenceWith(s2 *Set256) {
+	s1.sets[0].DifferenceWith(s2.sets[0])
+	s1.sets[1].DifferenceWith(s2.sets[1])
+	s1.sets[2].DifferenceWith(s2.sets[2])
+	s1.sets[3].DifferenceWith(s2.sets[3])
+}
+
+// SymmetricDifferenceWith sets s1 to the elements that are in exactly one of
+// s1 and s2.
+func (s1 *Set256) SymmetricDifferenceWith(s2 *Set256) {
+	s1.sets[0].SymmetricDifferenceWith(s2.sets[0])
+	s1.sets[1].SymmetricDifferenceWith(s2.sets[1])
+	s1.sets[2].SymmetricDifferenceWith(s2.sets[2])
+	s1.sets[3].SymmetricDifferenceWith(s2.sets[3])
+}
+
+// Rank returns the number of elements of s that are less than n.
+func (s *Set256) Rank(n uint8) int {
+	pos, _ := s.Position(n)
+	return pos
+}
+
+// Select returns the element of s whose rank is k; that is, the k'th smallest
+// element, counting from zero. The second return value is false if k is
+// out of range.
+func (s *Set256) Select(k int) (uint8, bool) {
+	if k < 0 {
+		return 0, false
+	}
+	for i, t := range s.sets {
+		sz := t.Size()
+		if k < sz {
+			e, _ := t.Select(k)
+			return uint8(i*64) + e, true
+		}
+		k -= sz
+	}
+	return 0, false
+}
+
+// Min returns the smallest element of s and true, or 0 and false if s is empty.
+func (s *Set256) Min() (uint8, bool) {
+	return s.next(0)
+}
+
+// Max returns the largest element of s and true, or 0 and false if s is empty.
+func (s *Set256) Max() (uint8, bool) {
+	return s.prev(255)
+}
+
+// NextAfter returns the smallest element of s that is greater than n, and
+// true. The second return value is false if there is no such element.
+func (s *Set256) NextAfter(n uint8) (uint8, bool) {
+	if n == 255 {
+		return 0, false
+	}
+	return s.next(n + 1)
+}
+
+// PrevBefore returns the largest element of s that is less than n, and
+// true. The second return value is false if there is no such element.
+func (s *Set256) PrevBefore(n uint8) (uint8, bool) {
+	if n == 0 {
+		return 0, false
+	}
+	return s.prev(n - 1)
+}
+
 // c = a intersect b
 // func (c *Set256) Intersect2(a, b *Set256) {
 // 	c.sets[0] = a.sets[0] & b.sets[0]
@@ -93,24 +258,36 @@ func (c *Set256) IntersectN(bs []*Set256) {
 	}
 }
 
+// Each calls f on each element of s in increasing order, until f returns false.
+func (s *Set256) Each(f func(uint8) bool) {
+	for i, t := range s.sets {
+		more := true
+		t.Each(func(u uint8) bool {
+			more = f(uint8(i*64) + u)
+			return more
+		})
+		if !more {
+			return
+		}
+	}
+}
+
 // Fill a with set elements, starting from start.
 // Return the number added.
 func (s *Set256) Elements(a []uint8, start uint8) int {
 	if len(a) == 0 {
 		return 0
 	}
-	si := start / 64
-	n := elements(s.sets[si], a, start%64, si*64)
-	for i := si + 1; i < 4; i++ {
-		n += elements(s.sets[i], a[n:], 0, i*64)
-	}
-	return n
-}
-
-func elements(s Set64, a []uint8, start, high uint8) int {
-	n := s.Elements(a, start)
-	for i := 0; i < n; i++ {
-		a[i] |= high
+	n := 0
+	for i := start / 64; i < 4 && n < len(a); i++ {
+		w := uint64(s.sets[i])
+		if i == start/64 {
+			w = w >> (start % 64) << (start % 64)
+		}
+		for ; w != 0 && n < len(a); n++ {
+			a[n] = i*64 + uint8(bits.TrailingZeros64(w))
+			w &= w - 1 // clear the lowest bit
+		}
 	}
 	return n
 }
@@ -121,35 +298,34 @@ func (s *Set256) Elements64(a []uint64, start uint8, high uint64) int {
 	}
 	si := start / 64
 	n := s.sets[si].Elements64(a, start%64, high|uint64(si*64))
-	for i := si + 1; i < 4; i++ {
+	for i := si + 1; i < 4 && n < len(a); i++ {
 		n += s.sets[i].Elements64(a[n:], 0, high|uint64(i*64))
 	}
 	return n
 }
 
+// String returns the elements of s in braces, as formatted by %v. So if s
+// has more than a hundred elements, String elides the rest.
 func (s Set256) String() string {
-	var a [256]uint64
-	n := s.Elements64(a[:], 0, 0)
-	if n == 0 {
-		return "{}"
-	}
-	// TODO: use strings.Builder
-	var buf bytes.Buffer
-	fmt.Fprintf(&buf, "{%d", a[0])
-	for _, e := range a[1:n] {
-		fmt.Fprintf(&buf, ", %d", e)
-	}
-	fmt.Fprint(&buf, "}")
-	return buf.String()
+	return fmt.Sprint(s)
 }
 
 // For subber, used in node:
 
-func (s *Set256) add(e uint64) { s.Add(uint8(e)) }
+func (s *Set256) add(e uint64) bool {
+	if s.Contains(uint8(e)) {
+		return false
+	}
+	s.Add(uint8(e))
+	return true
+}
 
-func (s *Set256) remove(e uint64) bool {
+func (s *Set256) remove(e uint64) (removed, empty bool) {
+	if !s.Contains(uint8(e)) {
+		return false, false
+	}
 	s.Remove(uint8(e))
-	return s.Empty()
+	return true, s.Empty()
 }
 
 func (s *Set256) contains(e uint64) bool {
@@ -158,12 +334,77 @@ func (s *Set256) contains(e uint64) bool {
 
 func (s *Set256) size() int { return s.Size() }
 
-func (s *Set256) memSize() uint64 { return memSize(*s) }
+func (s *Set256) rank(e uint64) int { return s.Rank(uint8(e)) }
+
+func (s *Set256) nth(k int) uint64 {
+	e, _ := s.Select(k)
+	return uint64(e)
+}
+
+func (s *Set256) memSize() uint64 { return allocSize(memSize(*s)) }
 
 func (s *Set256) elements(a []uint64, start, high uint64) int {
 	return s.Elements64(a, uint8(start), high)
 }
 
+func (s *Set256) addRangeSub(lo, hi uint64) subber {
+	s.AddRange(uint8(lo), uint8(hi))
+	return s
+}
+
+func (s *Set256) removeRangeSub(lo, hi uint64) subber {
+	s.RemoveRange(uint8(lo), uint8(hi))
+	if s.Empty() {
+		return nil
+	}
+	return s
+}
+
+func (s *Set256) flipRangeSub(lo, hi uint64) subber {
+	s.FlipRange(uint8(lo), uint8(hi))
+	if s.Empty() {
+		return nil
+	}
+	return s
+}
+
+func (s *Set256) containsAll(lo, hi uint64) bool {
+	return s.ContainsAll(uint8(lo), uint8(hi))
+}
+
+func (s *Set256) countRange(lo, hi uint64) int {
+	return s.Count(uint8(lo), uint8(hi))
+}
+
+func (s *Set256) intersectionSizeSub(b subber) int {
+	t := b.(*Set256)
+	return (s.sets[0] & t.sets[0]).Size() +
+		(s.sets[1] & t.sets[1]).Size() +
+		(s.sets[2] & t.sets[2]).Size() +
+		(s.sets[3] & t.sets[3]).Size()
+}
+
+func (s *Set256) subsetSub(b subber) bool { return s.IsSubsetOf(b.(*Set256)) }
+
+func (s *Set256) intersectsSub(b subber) bool { return s.Intersects(b.(*Set256)) }
+
 func (s *Set256) equalSub(b subber) bool {
 	return s.Equal(b.(*Set256))
 }
+
+func (s *Set256) copySub() subber {
+	c := *s
+	return &c
+}
+
+func (s *Set256) unionWithSub(b subber) { s.UnionWith(b.(*Set256)) }
+
+func (s *Set256) differenceWithSub(b subber) bool {
+	s.DifferenceWith(b.(*Set256))
+	return s.Empty()
+}
+
+func (s *Set256) symmetricDifferenceWithSub(b subber) bool {
+	s.SymmetricDifferenceWith(b.(*Set256))
+	return s.Empty()
+}
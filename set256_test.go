@@ -167,3 +167,122 @@ func TestIntersectN(t *testing.T) {
 		t.Fatal("bad c")
 	}
 }
+
+func TestBinaryOps256(t *testing.T) {
+	mk := func(els ...uint8) *Set256 {
+		var s Set256
+		for _, e := range els {
+			s.Add(e)
+		}
+		return &s
+	}
+	for _, test := range []struct {
+		name string
+		op   func(s1, s2 *Set256)
+		want *Set256
+	}{
+		{"intersect", (*Set256).IntersectWith, mk(3, 200)},
+		{"union", (*Set256).UnionWith, mk(3, 4, 70, 128, 200, 255)},
+		{"difference", (*Set256).DifferenceWith, mk(70, 255)},
+		{"symmetric difference", (*Set256).SymmetricDifferenceWith, mk(4, 70, 128, 255)},
+	} {
+		got := mk(3, 70, 200, 255)
+		test.op(got, mk(3, 4, 128, 200))
+		if !got.Equal(test.want) {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSubsetPredicates256(t *testing.T) {
+	mk := func(els ...uint8) *Set256 {
+		var s Set256
+		for _, e := range els {
+			s.Add(e)
+		}
+		return &s
+	}
+	for _, test := range []struct {
+		s1, s2          *Set256
+		subset, overlap bool
+	}{
+		{mk(), mk(), true, false},
+		{mk(), mk(3), true, false},
+		{mk(3), mk(), false, false},
+		{mk(3, 200), mk(3, 70, 200), true, true},
+		{mk(3, 70, 200), mk(3, 200), false, true},
+		{mk(1, 255), mk(2, 254), false, false},
+		{mk(255), mk(0, 64, 128, 255), true, true},
+	} {
+		if got := test.s1.IsSubsetOf(test.s2); got != test.subset {
+			t.Errorf("%s.IsSubsetOf(%s) = %t", test.s1, test.s2, got)
+		}
+		if got := test.s2.IsSupersetOf(test.s1); got != test.subset {
+			t.Errorf("%s.IsSupersetOf(%s) = %t", test.s2, test.s1, got)
+		}
+		if got := test.s1.Intersects(test.s2); got != test.overlap {
+			t.Errorf("%s.Intersects(%s) = %t", test.s1, test.s2, got)
+		}
+		if got := test.s2.IsDisjoint(test.s1); got == test.overlap {
+			t.Errorf("%s.IsDisjoint(%s) = %t", test.s2, test.s1, got)
+		}
+	}
+}
+
+func TestRanges256(t *testing.T) {
+	for _, r := range [][2]uint8{{0, 0}, {0, 255}, {3, 70}, {64, 127}, {100, 200}, {255, 255}, {5, 4}} {
+		lo, hi := r[0], r[1]
+		s := sampleSet256()
+		want := map[uint8]bool{}
+		for _, e := range naiveElementsUint64(&s) {
+			want[uint8(e)] = true
+		}
+		n := 0
+		all := true
+		for i := int(lo); i <= int(hi); i++ {
+			if want[uint8(i)] {
+				n++
+			} else {
+				all = false
+			}
+		}
+		if got := s.Count(lo, hi); got != n {
+			t.Errorf("Count(%d, %d) = %d, want %d", lo, hi, got, n)
+		}
+		if got := s.ContainsAll(lo, hi); got != all {
+			t.Errorf("ContainsAll(%d, %d) = %t, want %t", lo, hi, got, all)
+		}
+		f := s
+		f.FlipRange(lo, hi)
+		for i := 0; i < 256; i++ {
+			in := int(lo) <= i && i <= int(hi)
+			if f.Contains(uint8(i)) != (want[uint8(i)] != in) {
+				t.Fatalf("FlipRange(%d, %d): wrong membership of %d", lo, hi, i)
+			}
+		}
+		s.AddRange(lo, hi)
+		if lo <= hi && (!s.ContainsAll(lo, hi) || s.Size() != len(want)+int(hi-lo)+1-n) {
+			t.Errorf("AddRange(%d, %d): got %s", lo, hi, s)
+		}
+		s.RemoveRange(lo, hi)
+		if s.Count(lo, hi) != 0 || s.Size() != len(want)-n {
+			t.Errorf("RemoveRange(%d, %d): got %s", lo, hi, s)
+		}
+	}
+}
+
+func BenchmarkSet256Elements(b *testing.B) {
+	var a [256]uint8
+	for _, d := range densities {
+		ws := densitySet64s(d.shift)
+		ss := make([]Set256, len(ws)/4)
+		for i := range ss {
+			copy(ss[i].sets[:], ws[i*4:])
+		}
+		b.Run(d.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				ss[i%len(ss)].Elements(a[:], 0)
+			}
+		})
+	}
+}
@@ -1,7 +1,6 @@
 package bit
 
 import (
-	"bytes"
 	"fmt"
 	"math/bits"
 )
@@ -9,6 +8,7 @@ import (
 // Set64 is an efficient representation of a bitset that can represent integers
 // in the range [0, 64).
 // For efficiency, the methods of Set64 perform no bounds checking on their arguments.
+// See AtomicSet64 for a version that is safe for concurrent use.
 type Set64 uint64
 
 func (s *Set64) Add(u uint8) {
@@ -51,6 +51,59 @@ func (s Set64) Position(n uint8) (int, bool) {
 	return pos, in
 }
 
+// Rank returns the number of elements of s that are less than n.
+func (s Set64) Rank(n uint8) int {
+	pos, _ := s.Position(n)
+	return pos
+}
+
+// Select returns the element of s whose rank is k; that is, the k'th smallest
+// element, counting from zero. The second return value is false if k is
+// out of range.
+func (s Set64) Select(k int) (uint8, bool) {
+	if k < 0 || k >= s.Size() {
+		return 0, false
+	}
+	w := uint64(s)
+	for ; k > 0; k-- {
+		w &= w - 1 // clear the lowest bit
+	}
+	return uint8(bits.TrailingZeros64(w)), true
+}
+
+// Min returns the smallest element of s and true, or 0 and false if s is empty.
+func (s Set64) Min() (uint8, bool) {
+	return s.next(0)
+}
+
+// Max returns the largest element of s and true, or 0 and false if s is empty.
+func (s Set64) Max() (uint8, bool) {
+	return s.prev(63)
+}
+
+// NextAfter returns the smallest element of s that is greater than u, and
+// true. The second return value is false if there is no such element.
+func (s Set64) NextAfter(u uint8) (uint8, bool) {
+	if u >= 63 {
+		return 0, false
+	}
+	return s.next(u + 1)
+}
+
+// PrevBefore returns the largest element of s that is less than u, and
+// true. The second return value is false if there is no such element.
+func (s Set64) PrevBefore(u uint8) (uint8, bool) {
+	if u == 0 {
+		return 0, false
+	}
+	return s.prev(u - 1)
+}
+
+// rangeMask returns the set of elements in [lo, hi]. It requires lo <= hi < 64.
+func rangeMask(lo, hi uint8) Set64 {
+	return Set64(^uint64(0) >> (63 - hi) &^ (1<<lo - 1))
+}
+
 func (s1 *Set64) IntersectWith(s2 Set64) {
 	*s1 &= s2
 }
@@ -59,16 +112,46 @@ func (s1 *Set64) UnionWith(s2 Set64) {
 	*s1 |= s2
 }
 
+func (s1 *Set64) DifferenceWith(s2 Set64) {
+	*s1 &^= s2
+}
+
+func (s1 *Set64) SymmetricDifferenceWith(s2 Set64) {
+	*s1 ^= s2
+}
+
+// IsSubsetOf reports whether every element of s1 is in s2.
+func (s1 Set64) IsSubsetOf(s2 Set64) bool { return s1&^s2 == 0 }
+
+// IsSupersetOf reports whether every element of s2 is in s1.
+func (s1 Set64) IsSupersetOf(s2 Set64) bool { return s2.IsSubsetOf(s1) }
+
+// Intersects reports whether s1 and s2 have an element in common.
+func (s1 Set64) Intersects(s2 Set64) bool { return s1&s2 != 0 }
+
+// IsDisjoint reports whether s1 and s2 have no elements in common.
+func (s1 Set64) IsDisjoint(s2 Set64) bool { return !s1.Intersects(s2) }
+
+// Each calls f on each element of s in increasing order, until f returns false.
+func (s Set64) Each(f func(uint8) bool) {
+	w := uint64(s)
+	for w != 0 {
+		if !f(uint8(bits.TrailingZeros64(w))) {
+			return
+		}
+		w &= w - 1 // clear the lowest bit
+	}
+}
+
 func (s Set64) Elements(a []uint8, start uint8) int {
 	if len(a) == 0 {
 		return 0
 	}
+	w := uint64(s) >> start << start
 	i := 0
-	for b := start; b < 64 && i < len(a); b++ {
-		if s.Contains(b) {
-			a[i] = b
-			i++
-		}
+	for ; w != 0 && i < len(a); i++ {
+		a[i] = uint8(bits.TrailingZeros64(w))
+		w &= w - 1 // clear the lowest bit
 	}
 	return i
 }
@@ -78,28 +161,16 @@ func (s Set64) Elements64(a []uint64, start uint8, high uint64) int {
 	if len(a) == 0 {
 		return 0
 	}
+	w := uint64(s) >> start << start
 	i := 0
-	for b := start; b < 64 && i < len(a); b++ {
-		if s.Contains(b) {
-			a[i] = high | uint64(b)
-			i++
-		}
+	for ; w != 0 && i < len(a); i++ {
+		a[i] = high | uint64(bits.TrailingZeros64(w))
+		w &= w - 1 // clear the lowest bit
 	}
 	return i
 }
 
+// String returns the elements of s in braces, as formatted by %v.
 func (s Set64) String() string {
-	var a [64]uint8
-	n := s.Elements(a[:], 0)
-	if n == 0 {
-		return "{}"
-	}
-	// TODO: use strings.Builder
-	var buf bytes.Buffer
-	fmt.Fprintf(&buf, "{%d", a[0])
-	for _, e := range a[1:n] {
-		fmt.Fprintf(&buf, ", %d", e)
-	}
-	fmt.Fprint(&buf, "}")
-	return buf.String()
+	return fmt.Sprint(s)
 }
@@ -1,10 +1,12 @@
 package bit
 
 import (
+	"math/rand"
 	"reflect"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 )
 
 func sampleSet64() Set64 {
@@ -139,3 +141,100 @@ func naiveElementsUint8(s interface {
 	}
 	return els
 }
+
+func TestSelect(t *testing.T) {
+	s := sampleSet64()
+	for k, want := range []uint8{3, 17, 63} {
+		if got, ok := s.Select(k); !ok || got != want {
+			t.Errorf("Select(%d) = %d, %t, want %d, true", k, got, ok, want)
+		}
+	}
+	if _, ok := s.Select(3); ok {
+		t.Error("Select(3) succeeded")
+	}
+}
+
+func TestSubsetPredicates(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		s1 := Set64(rand.Uint64() & rand.Uint64())
+		s2 := Set64(rand.Uint64() | rand.Uint64())
+		if i%2 == 0 {
+			s1 &= s2
+		}
+		sub, inter := true, false
+		for _, e := range naiveElementsUint8(&s1) {
+			if s2.Contains(e) {
+				inter = true
+			} else {
+				sub = false
+			}
+		}
+		if got := s1.IsSubsetOf(s2); got != sub {
+			t.Errorf("%s.IsSubsetOf(%s) = %t", s1, s2, got)
+		}
+		if got := s2.IsSupersetOf(s1); got != sub {
+			t.Errorf("%s.IsSupersetOf(%s) = %t", s2, s1, got)
+		}
+		if got := s1.Intersects(s2); got != inter {
+			t.Errorf("%s.Intersects(%s) = %t", s1, s2, got)
+		}
+		if got := s1.IsDisjoint(s2); got == inter {
+			t.Errorf("%s.IsDisjoint(%s) = %t", s1, s2, got)
+		}
+	}
+}
+
+func TestElementsRandom(t *testing.T) {
+	// Compare against probing each bit, at many densities, starts and
+	// buffer lengths.
+	for i := 0; i < 1000; i++ {
+		s := Set64(rand.Uint64() & rand.Uint64() & rand.Uint64())
+		if i%2 == 0 {
+			s = ^s
+		}
+		start := uint8(rand.Intn(70))
+		var want []uint8
+		for _, e := range naiveElementsUint8(&s) {
+			if e >= start {
+				want = append(want, e)
+			}
+		}
+		a := make([]uint8, rand.Intn(len(want)+2))
+		n := s.Elements(a, start)
+		if w := want[:min(len(a), len(want))]; !cmp.Equal(a[:n], w, cmpopts.EquateEmpty()) {
+			t.Fatalf("%s.Elements(%d) with buffer %d: got %v, want %v", s, start, len(a), a[:n], w)
+		}
+	}
+}
+
+// densitySet64s returns 1024 words in which about one bit in every
+// 1<<shift is set.
+func densitySet64s(shift int) []Set64 {
+	r := rand.New(rand.NewSource(1))
+	ws := make([]Set64, 1024)
+	for i := range ws {
+		for j := 0; j < 64; j++ {
+			if r.Intn(1<<shift) == 0 {
+				ws[i].Add(uint8(j))
+			}
+		}
+	}
+	return ws
+}
+
+var densities = []struct {
+	name  string
+	shift int
+}{{"1in64", 6}, {"1in8", 3}, {"1in2", 1}, {"full", 0}}
+
+func BenchmarkSet64Elements(b *testing.B) {
+	var a [64]uint64
+	for _, d := range densities {
+		ws := densitySet64s(d.shift)
+		b.Run(d.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				ws[i%len(ws)].Elements64(a[:], 0, 0)
+			}
+		})
+	}
+}
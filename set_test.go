@@ -0,0 +1,187 @@
+package bit
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func newSet(capacity int, els ...int) *Set {
+	s := NewSet(capacity)
+	for _, e := range els {
+		s.Add(e)
+	}
+	return s
+}
+
+func setElements(s *Set) []int {
+	var els []int
+	for i := 0; i < s.Capacity(); i++ {
+		if s.Contains(i) {
+			els = append(els, i)
+		}
+	}
+	return els
+}
+
+func TestSetBasics(t *testing.T) {
+	s := newSet(200, 0, 3, 64, 130, 199)
+	if got, want := s.Capacity(), 256; got != want {
+		t.Errorf("Capacity() = %d, want %d", got, want)
+	}
+	if got, want := setElements(s), []int{0, 3, 64, 130, 199}; !cmp.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if s.Size() != 5 {
+		t.Errorf("Size() = %d, want 5", s.Size())
+	}
+	s.Remove(64)
+	if s.Contains(64) || s.Contains(1) {
+		t.Error("contains wrong element")
+	}
+}
+
+func TestSetBinaryOps(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		inPlace func(s1, s2 *Set)
+		alloc   func(s *Set, ss ...*Set)
+		want    []int
+		wantCap int
+	}{
+		{"union", (*Set).UnionWith, (*Set).Union, []int{1, 64, 65, 100, 300}, 320},
+		{"intersect", (*Set).IntersectWith, (*Set).Intersect, []int{64}, 128},
+		{"difference", (*Set).DifferenceWith, (*Set).Difference, []int{1, 100}, 128},
+		{"symmetric difference", (*Set).SymmetricDifferenceWith, (*Set).SymmetricDifference, []int{1, 65, 100, 300}, 320},
+	} {
+		s1 := newSet(128, 1, 64, 100)
+		s2 := newSet(320, 64, 65, 300)
+		var got Set
+		test.alloc(&got, s1, s2)
+		if els := setElements(&got); !cmp.Equal(els, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, els, test.want)
+		}
+		if got.Capacity() != test.wantCap {
+			t.Errorf("%s: capacity %d, want %d", test.name, got.Capacity(), test.wantCap)
+		}
+		test.inPlace(s1, s2)
+		if !s1.Equal(&got) {
+			t.Errorf("%s in place: got %v, want %v", test.name, setElements(s1), test.want)
+		}
+		if s2.Size() != 3 {
+			t.Errorf("%s: argument modified", test.name)
+		}
+	}
+
+	// The result of Intersect has the capacity of the first argument, even
+	// when it is the larger.
+	var got Set
+	got.Intersect(newSet(320, 1, 64), newSet(64, 1))
+	if got.Capacity() != 320 || !got.Equal(newSet(64, 1)) {
+		t.Errorf("Intersect: got %v with capacity %d", setElements(&got), got.Capacity())
+	}
+}
+
+func TestSetSubsetPredicates(t *testing.T) {
+	for _, test := range []struct {
+		s1, s2          *Set
+		subset, overlap bool
+	}{
+		{newSet(0), newSet(64), true, false},
+		{newSet(64, 3), newSet(0), false, false},
+		{newSet(64, 3, 5), newSet(640, 3, 5, 600), true, true},
+		{newSet(640, 3, 5, 600), newSet(64, 3, 5), false, true},
+		{newSet(640, 3), newSet(64, 3, 5), true, true},
+		{newSet(640, 600), newSet(64, 3, 5), false, false},
+		{newSet(128, 70), newSet(128, 71), false, false},
+	} {
+		if got := test.s1.IsSubsetOf(test.s2); got != test.subset {
+			t.Errorf("%v.IsSubsetOf(%v) = %t", setElements(test.s1), setElements(test.s2), got)
+		}
+		if got := test.s2.IsSupersetOf(test.s1); got != test.subset {
+			t.Errorf("%v.IsSupersetOf(%v) = %t", setElements(test.s2), setElements(test.s1), got)
+		}
+		if got := test.s1.Intersects(test.s2); got != test.overlap {
+			t.Errorf("%v.Intersects(%v) = %t", setElements(test.s1), setElements(test.s2), got)
+		}
+		if got := test.s2.IsDisjoint(test.s1); got == test.overlap {
+			t.Errorf("%v.IsDisjoint(%v) = %t", setElements(test.s2), setElements(test.s1), got)
+		}
+	}
+}
+
+func TestSetEqualCopyComplement(t *testing.T) {
+	s1 := newSet(64, 3, 5)
+	s2 := newSet(640, 3, 5)
+	if !s1.Equal(s2) || !s2.Equal(s1) {
+		t.Error("sets with different capacities should be equal")
+	}
+	s2.Add(600)
+	if s1.Equal(s2) || s2.Equal(s1) {
+		t.Error("sets should not be equal")
+	}
+	c := s2.Copy()
+	if !c.Equal(s2) || c.Capacity() != s2.Capacity() {
+		t.Error("copy not equal")
+	}
+	c.Add(7)
+	if s2.Contains(7) {
+		t.Error("copy shares storage")
+	}
+	s1.Complement()
+	if s1.Size() != 62 || s1.Contains(3) || !s1.Contains(63) {
+		t.Errorf("bad complement: %v", setElements(s1))
+	}
+}
+
+func TestSetRankSelect(t *testing.T) {
+	s := newSet(300, 0, 3, 64, 130, 299)
+	for _, test := range []struct {
+		i, rank int
+	}{
+		{-1, 0}, {0, 0}, {1, 1}, {4, 2}, {64, 2}, {65, 3}, {299, 4}, {300, 5}, {1000, 5},
+	} {
+		if got := s.Rank(test.i); got != test.rank {
+			t.Errorf("Rank(%d) = %d, want %d", test.i, got, test.rank)
+		}
+	}
+	for k, want := range []int{0, 3, 64, 130, 299} {
+		if got, ok := s.Select(k); !ok || got != want {
+			t.Errorf("Select(%d) = %d, %t, want %d, true", k, got, ok, want)
+		}
+	}
+	if _, ok := s.Select(5); ok {
+		t.Error("Select(5) succeeded")
+	}
+}
+
+func TestSetRanges(t *testing.T) {
+	s := newSet(300, 5, 64, 130)
+	s.AddRange(60, 200)
+	if got, want := s.Size(), 142; got != want {
+		t.Errorf("Size() = %d, want %d", got, want)
+	}
+	if !s.ContainsAll(60, 200) || s.ContainsAll(59, 200) || s.ContainsAll(60, 1000) {
+		t.Error("bad ContainsAll")
+	}
+	if got := s.Count(0, 63); got != 5 {
+		t.Errorf("Count(0, 63) = %d, want 5", got)
+	}
+	if got := s.Count(-10, 1000); got != 142 {
+		t.Errorf("Count(-10, 1000) = %d, want 142", got)
+	}
+	s.RemoveRange(61, 199)
+	if got, want := setElements(s), []int{5, 60, 200}; !cmp.Equal(got, want) {
+		t.Errorf("after RemoveRange: got %v, want %v", got, want)
+	}
+	s.FlipRange(0, 63)
+	if s.Contains(5) || s.Contains(60) || !s.Contains(0) || !s.Contains(63) || s.Size() != 63 {
+		t.Errorf("after FlipRange: got %v", setElements(s))
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("AddRange beyond capacity did not panic")
+		}
+	}()
+	s.AddRange(0, 320)
+}
@@ -2,44 +2,186 @@
 package bit
 
 import (
-	"bytes"
 	"fmt"
+	"math"
 	"reflect"
+	"slices"
 )
 
+// A SparseSet is a set of uint64s, stored in a compact radix tree.
+// It uses memory in proportion to the number of elements, not their range.
+//
+// The tree only has the levels needed to tell its elements apart. Its root
+// is the lowest node that holds every element; the bits above those the root
+// indexes are the same for all elements, and are stored once, as a prefix,
+// instead of in a chain of nodes with one subnode each. So a set whose
+// elements all fit in 32 bits, or all lie within 2^32 of each other, has a
+// tree of three levels above the leaves rather than seven, and its
+// operations are correspondingly faster. Below the root, a path of nodes with
+// a single subnode each is compressed in the same way, into the indices
+// along the path and the subtree at its end. So a cluster of elements far
+// from the rest of the set, like a few large IDs, costs one small object
+// instead of a node at each level, and a search goes straight to the
+// cluster.
+//
+// A SparseSet is not safe for concurrent use if any goroutine modifies it;
+// see PersistentSet and ConcurrentSparseSet.
 type SparseSet struct {
-	root *node // compact radix tree 7 levels deep.
+	root *node // compact radix tree
+	// prefix holds the bits of every element above those the root indexes.
+	// The other bits are zero.
+	prefix uint64
+}
+
+// commonShift returns the shift of the lowest node that can hold both a and
+// b: the smallest shift, at least 8, at which they agree in all the bits
+// above those the node indexes.
+func commonShift(a, b uint64) uint {
+	shift := uint(8)
+	for shift < 64-8 && a>>(shift+8) != b>>(shift+8) {
+		shift += 8
+	}
+	return shift
+}
+
+// mask returns the bits of an element that n and its descendants index.
+func (n *node) mask() uint64 {
+	return uint64(1)<<(n.shift+8) - 1
+}
+
+// covers reports whether e has the prefix of s, so that s's root could hold
+// it. It reports false if s is empty.
+func (s *SparseSet) covers(e uint64) bool {
+	return s.root != nil && e&^s.root.mask() == s.prefix
+}
+
+// rootMax returns the largest element that the root of s can hold.
+// s must not be empty.
+func (s *SparseSet) rootMax() uint64 {
+	return s.prefix | s.root.mask()
+}
+
+// lift returns n if its shift is at least shift. Otherwise it returns a new
+// node with the given shift, whose single subnode leads to n, with the
+// indices along the way taken from prefix, the prefix of n.
+// lift also returns the prefix of the result. It does not modify n.
+func lift(n *node, prefix uint64, shift uint) (*node, uint64) {
+	if n.shift < shift {
+		index := uint8(prefix >> shift)
+		sub := newChain(shift-8, prefix, compact(n))
+		p := &node{shift: shift, count: n.count, subnodes: []subnode{{index: index, sub: sub}}}
+		p.bitset.Add(index)
+		n = p
+	}
+	return n, prefix &^ n.mask()
+}
+
+// lifted returns the root of s lifted to at least shift, and its prefix.
+func (s *SparseSet) lifted(shift uint) (*node, uint64) {
+	return lift(s.root, s.prefix, shift)
+}
+
+// grow makes the root of s able to hold e.
+func (s *SparseSet) grow(e uint64) {
+	if s.root == nil {
+		s.root = &node{shift: 8}
+		s.prefix = e &^ s.root.mask()
+		return
+	}
+	s.growTo(commonShift(s.prefix, e))
+}
+
+// growTo makes the shift of the root of s at least shift. s must not be empty.
+func (s *SparseSet) growTo(shift uint) {
+	s.root, s.prefix = s.lifted(shift)
+}
+
+// trimRoot removes nodes with a single subnode from the top of the tree,
+// moving their indices into the prefix. It does not modify the nodes.
+func (s *SparseSet) trimRoot() {
+	for s.root != nil && len(s.root.subnodes) == 1 {
+		sn := s.root.subnodes[0]
+		high := s.prefix | uint64(sn.index)<<s.root.shift
+		switch c := sn.sub.(type) {
+		case *node:
+			s.root, s.prefix = c, high
+		case *chain:
+			s.root, s.prefix = c.root(high)
+		default:
+			return
+		}
+	}
+}
+
+// commonRootShift returns the shift of the lowest root that can hold the
+// elements of both s1 and s2, which must not be empty.
+func commonRootShift(s1, s2 *SparseSet) uint {
+	return max(s1.root.shift, s2.root.shift, commonShift(s1.prefix, s2.prefix))
+}
+
+// align lifts the root of s1 so that it can hold the elements of s2, and
+// returns s2's root lifted to the same height. Neither set may be empty.
+// It does not modify s2.
+func (s1 *SparseSet) align(s2 *SparseSet) *node {
+	s1.growTo(commonRootShift(s1, s2))
+	n, _ := s2.lifted(s1.root.shift)
+	return n
 }
 
 func NewSparseSet(els ...uint64) *SparseSet {
 	s := &SparseSet{}
-	for _, e := range els {
-		s.Add(e)
+	s.AddMany(els...)
+	return s
+}
+
+// NewSparseSetFromSorted returns a SparseSet with the elements of els, which
+// must be sorted in increasing order. Duplicates are allowed.
+// It builds the tree bottom-up, allocating each node once, so it is much
+// faster than adding the elements one at a time.
+// NewSparseSetFromSorted panics if els is not sorted.
+func NewSparseSetFromSorted(els []uint64) *SparseSet {
+	if !slices.IsSorted(els) {
+		panic("bit: NewSparseSetFromSorted: elements are not sorted")
+	}
+	if len(els) == 0 {
+		return &SparseSet{}
 	}
+	shift := commonShift(els[0], els[len(els)-1])
+	s := &SparseSet{root: buildNode(shift, els)}
+	s.prefix = els[0] &^ s.root.mask()
 	return s
 }
 
-func (s *SparseSet) Add(n uint64) {
-	if s.root == nil {
-		s.root = &node{shift: 64 - 8}
+// AddMany adds els to s. It is faster than calling Add for each element,
+// especially if els is sorted.
+func (s *SparseSet) AddMany(els ...uint64) {
+	if len(els) == 0 {
+		return
 	}
+	if !slices.IsSorted(els) {
+		els = slices.Clone(els)
+		slices.Sort(els)
+	}
+	s.absorb(NewSparseSetFromSorted(els))
+}
+
+func (s *SparseSet) Add(n uint64) {
+	s.grow(n)
 	s.root.add(n)
 }
 
 func (s *SparseSet) Remove(n uint64) {
-	if s.root == nil {
+	if !s.covers(n) {
 		return
 	}
-	if s.root.remove(uint64(n)) {
-		s.root = nil
+	if _, empty := s.root.remove(n); empty {
+		s.Clear()
 	}
+	s.trimRoot()
 }
 
 func (s *SparseSet) Contains(n uint64) bool {
-	if s.root == nil {
-		return false
-	}
-	return s.root.contains(n)
+	return s.covers(n) && s.root.contains(n)
 }
 
 func (s *SparseSet) Empty() bool {
@@ -47,18 +189,33 @@ func (s *SparseSet) Empty() bool {
 }
 
 func (s *SparseSet) Clear() {
-	s.root = nil
+	*s = SparseSet{}
 }
 
 func (s1 *SparseSet) Equal(s2 *SparseSet) bool {
 	if s1.root == nil || s2.root == nil {
 		return s1.root == s2.root
 	}
-	return s1.root.equal(s2.root)
+	shift := commonRootShift(s1, s2)
+	n1, _ := s1.lifted(shift)
+	n2, _ := s2.lifted(shift)
+	return n1.equal(n2)
 }
 
-// TODO: copy
+// Copy returns a copy of s that does not share any storage with it.
+func (s *SparseSet) Copy() *SparseSet {
+	if s.root == nil {
+		return &SparseSet{}
+	}
+	return &SparseSet{root: s.root.copy(), prefix: s.prefix}
+}
 
+// Size returns the number of elements in s. It takes constant time, because
+// each node keeps a count of the elements in its subtree.
+//
+// A SparseSet can hold more elements than an int can count. If s has more
+// than math.MaxInt elements, Size returns math.MaxInt. The same holds for the
+// other methods that count elements, like Count, Rank and IntersectionSize.
 func (s *SparseSet) Size() int {
 	if s.root == nil {
 		return 0
@@ -66,6 +223,248 @@ func (s *SparseSet) Size() int {
 	return s.root.size()
 }
 
+// IntersectionSize returns the number of elements in both s1 and s2, without
+// constructing their intersection. Subtrees that are in only one of the sets
+// are skipped, and the counts of full subtrees are used without visiting them.
+func (s1 *SparseSet) IntersectionSize(s2 *SparseSet) int {
+	if s1.root == nil || s2.root == nil {
+		return 0
+	}
+	if s1 == s2 {
+		return s1.Size()
+	}
+	shift := commonRootShift(s1, s2)
+	n1, p1 := s1.lifted(shift)
+	n2, p2 := s2.lifted(shift)
+	if p1 != p2 {
+		return 0
+	}
+	return intersectionSize(n1, n2)
+}
+
+// subtree returns the subtree of s at the level of a node with the given
+// shift that holds the elements beginning with prefix, or nil if s has no
+// such elements. The bits of prefix that the subtree indexes are ignored.
+// The root of s must be at least that high.
+func (s *SparseSet) subtree(prefix uint64, shift uint) subber {
+	if !s.covers(prefix) {
+		return nil
+	}
+	var sub subber = s.root
+	for {
+		switch n := sub.(type) {
+		case full:
+			return full{shift: shift}
+		case *chain:
+			above := n.pathMask() &^ (uint64(1)<<(shift+8) - 1)
+			if (prefix^n.prefix)&above != 0 {
+				return nil
+			}
+			if level(n.sub) <= shift+8 {
+				return newChain(shift, n.prefix, n.sub)
+			}
+			sub = n.sub
+		case *node:
+			if n.shift == shift {
+				return n
+			}
+			p, found := n.bitset.Position(uint8(prefix >> n.shift))
+			if !found {
+				return nil
+			}
+			sub = n.subnodes[p].sub
+		}
+	}
+}
+
+// IsSubsetOf reports whether every element of s1 is in s2.
+// It compares the trees level by level, and returns as soon as it finds an
+// element of s1 that is not in s2, without visiting the rest of the trees.
+func (s1 *SparseSet) IsSubsetOf(s2 *SparseSet) bool {
+	switch {
+	case s1.root == nil || s1 == s2:
+		return true
+	case s1.Size() > s2.Size():
+		return false
+	case s1.root.shift <= s2.root.shift:
+		sub := s2.subtree(s1.prefix, s1.root.shift)
+		return sub != nil && isSubset(s1.root, sub)
+	default:
+		// All of s1 must lie in the part of its tree that s2's root spans.
+		sub := s1.subtree(s2.prefix, s2.root.shift)
+		return sub != nil && sub.size() == s1.Size() && isSubset(sub, s2.root)
+	}
+}
+
+// IsSupersetOf reports whether every element of s2 is in s1.
+func (s1 *SparseSet) IsSupersetOf(s2 *SparseSet) bool { return s2.IsSubsetOf(s1) }
+
+// Intersects reports whether s1 and s2 have an element in common.
+// It descends only into subtrees that are present in both sets, and returns
+// as soon as it finds a common element.
+func (s1 *SparseSet) Intersects(s2 *SparseSet) bool {
+	if s1.root == nil || s2.root == nil {
+		return false
+	}
+	if s1.root.shift > s2.root.shift {
+		s1, s2 = s2, s1
+	}
+	sub := s2.subtree(s1.prefix, s1.root.shift)
+	return sub != nil && intersects(s1.root, sub)
+}
+
+// IsDisjoint reports whether s1 and s2 have no elements in common.
+func (s1 *SparseSet) IsDisjoint(s2 *SparseSet) bool { return !s1.Intersects(s2) }
+
+// UnionSize returns the number of elements in s1 or s2, without constructing
+// their union.
+func (s1 *SparseSet) UnionSize(s2 *SparseSet) int {
+	n1, n2 := s1.Size(), s2.Size()
+	if n1 == math.MaxInt || n2 == math.MaxInt {
+		return math.MaxInt
+	}
+	// The intersection size is exact, since it is at most n2.
+	return addCounts(n1, n2-s1.IntersectionSize(s2))
+}
+
+// The range methods operate on the elements in the closed interval [lo, hi].
+// If lo > hi, the interval is empty. They work on whole subtrees of the
+// radix tree at once, so their running time depends on the number of nodes
+// that overlap the interval rather than on the number of elements in it.
+
+// AddRange adds the elements in [lo, hi] to s.
+func (s *SparseSet) AddRange(lo, hi uint64) {
+	if lo > hi {
+		return
+	}
+	s.grow(lo)
+	s.grow(hi)
+	s.root.addRange(lo, hi)
+}
+
+// RemoveRange removes the elements in [lo, hi] from s.
+func (s *SparseSet) RemoveRange(lo, hi uint64) {
+	if s.root == nil {
+		return
+	}
+	lo, hi = max(lo, s.prefix), min(hi, s.rootMax())
+	if lo > hi {
+		return
+	}
+	if s.root.removeRange(lo, hi) {
+		s.Clear()
+	}
+	s.trimRoot()
+}
+
+// FlipRange adds the elements in [lo, hi] that are not in s to s, and
+// removes those that are.
+func (s *SparseSet) FlipRange(lo, hi uint64) {
+	if lo > hi {
+		return
+	}
+	s.grow(lo)
+	s.grow(hi)
+	if s.root.flipRange(lo, hi) {
+		s.Clear()
+	}
+	s.trimRoot()
+}
+
+// ContainsAll reports whether every element in [lo, hi] is in s.
+func (s *SparseSet) ContainsAll(lo, hi uint64) bool {
+	if lo > hi {
+		return true
+	}
+	return s.covers(lo) && s.covers(hi) && s.root.containsAll(lo, hi)
+}
+
+// Count returns the number of elements of s in [lo, hi].
+func (s *SparseSet) Count(lo, hi uint64) int {
+	if s.root == nil {
+		return 0
+	}
+	lo, hi = max(lo, s.prefix), min(hi, s.rootMax())
+	if lo > hi {
+		return 0
+	}
+	return s.root.countRange(lo, hi)
+}
+
+// Rank returns the number of elements of s that are less than n.
+// It takes time proportional to the depth of the tree.
+func (s *SparseSet) Rank(n uint64) int {
+	switch {
+	case s.root == nil || n < s.prefix:
+		return 0
+	case n > s.rootMax():
+		return s.root.size()
+	}
+	return s.root.rank(n)
+}
+
+// Select returns the element of s whose rank is k; that is, the k'th smallest
+// element, counting from zero. The second return value is false if k is
+// out of range. Select takes time proportional to the depth of the tree.
+func (s *SparseSet) Select(k int) (uint64, bool) {
+	if k < 0 || k >= s.Size() {
+		return 0, false
+	}
+	return s.prefix | s.root.nth(k), true
+}
+
+// Min returns the smallest element of s and true, or 0 and false if s is empty.
+func (s *SparseSet) Min() (uint64, bool) {
+	if s.root == nil {
+		return 0, false
+	}
+	return firstSub(s.root, s.prefix), true
+}
+
+// Max returns the largest element of s and true, or 0 and false if s is empty.
+func (s *SparseSet) Max() (uint64, bool) {
+	if s.root == nil {
+		return 0, false
+	}
+	return lastSub(s.root, s.prefix), true
+}
+
+// NextAfter returns the smallest element of s that is greater than n, and
+// true. The second return value is false if there is no such element.
+// It takes time proportional to the depth of the tree.
+func (s *SparseSet) NextAfter(n uint64) (uint64, bool) {
+	switch {
+	case s.root == nil || n >= s.rootMax():
+		return 0, false
+	case n < s.prefix:
+		return s.Min()
+	}
+	return nextSub(s.root, n+1)
+}
+
+// PrevBefore returns the largest element of s that is less than n, and
+// true. The second return value is false if there is no such element.
+// It takes time proportional to the depth of the tree.
+// To find the largest element that is at most n, use PrevBefore(n+1),
+// or check Contains(n) first if n may be math.MaxUint64.
+func (s *SparseSet) PrevBefore(n uint64) (uint64, bool) {
+	if s.root == nil || n <= s.prefix {
+		return 0, false
+	}
+	return prevSub(s.root, min(n-1, s.rootMax()))
+}
+
+// Compact releases the memory that s keeps in reserve for adding elements.
+// Call it when s will not change for a while.
+func (s *SparseSet) Compact() {
+	if s.root != nil {
+		s.root.trim()
+	}
+}
+
+// MemSize returns the number of bytes of memory used by s. It includes the
+// unused capacity of slices and the rounding up of each allocation to one of
+// the Go allocator's size classes.
 func (s *SparseSet) MemSize() uint64 {
 	sz := memSize(*s)
 	if s.root != nil {
@@ -78,11 +477,21 @@ func memSize(x interface{}) uint64 {
 	return uint64(reflect.TypeOf(x).Size())
 }
 
+// Each calls f on each element of s in increasing order, until f returns false.
+func (s *SparseSet) Each(f func(uint64) bool) {
+	it := s.Iterator()
+	for e, ok := it.Next(); ok; e, ok = it.Next() {
+		if !f(e) {
+			return
+		}
+	}
+}
+
 func (s *SparseSet) Elements(a []uint64, start uint64) int {
-	if s.root == nil {
+	if s.root == nil || start > s.rootMax() {
 		return 0
 	}
-	return s.root.elements(a, start, 0)
+	return s.root.elements(a, max(start, s.prefix), s.prefix)
 }
 
 // TODO: rethink
@@ -91,26 +500,124 @@ func (s *SparseSet) Elements(a []uint64, start uint64) int {
 func (s *SparseSet) Intersect(ss ...*SparseSet) {
 	s.Clear()
 	var nodes []*node
+	var shift uint
 	for _, t := range ss {
 		if t.Empty() {
 			return
 		}
-		nodes = append(nodes, t.root)
+		shift = max(shift, commonRootShift(ss[0], t))
+	}
+	var prefix uint64
+	for _, t := range ss {
+		var n *node
+		n, prefix = t.lifted(shift)
+		nodes = append(nodes, n)
+	}
+	if r := intersectNodes(nodes); r != nil {
+		s.root, s.prefix = r, prefix
+		s.trimRoot()
+	}
+}
+
+// UnionWith sets s1 to the union of s1 and s2.
+func (s1 *SparseSet) UnionWith(s2 *SparseSet) {
+	if s1 == s2 || s2.root == nil {
+		return
+	}
+	if s1.root == nil {
+		*s1 = *s2.Copy()
+		return
+	}
+	s1.root.unionWith(s1.align(s2))
+}
+
+// absorb is UnionWith for an s2 that is no longer needed. The subtrees that
+// only s2 has become part of s1 instead of being copied.
+func (s1 *SparseSet) absorb(s2 *SparseSet) {
+	if s2.root == nil {
+		return
+	}
+	if s1.root == nil {
+		*s1 = *s2
+		return
+	}
+	s1.root.absorb(s1.align(s2))
+}
+
+// DifferenceWith removes the elements of s2 from s1.
+func (s1 *SparseSet) DifferenceWith(s2 *SparseSet) {
+	if s1 == s2 {
+		s1.Clear()
+		return
+	}
+	if s1.root == nil || s2.root == nil {
+		return
+	}
+	if s1.root.differenceWith(s1.align(s2)) {
+		s1.Clear()
+	}
+	s1.trimRoot()
+}
+
+// SymmetricDifferenceWith sets s1 to the elements that are in exactly one of
+// s1 and s2.
+func (s1 *SparseSet) SymmetricDifferenceWith(s2 *SparseSet) {
+	if s1 == s2 {
+		s1.Clear()
+		return
+	}
+	if s2.root == nil {
+		return
+	}
+	if s1.root == nil {
+		*s1 = *s2.Copy()
+		return
+	}
+	if s1.root.symmetricDifferenceWith(s1.align(s2)) {
+		s1.Clear()
+	}
+	s1.trimRoot()
+}
+
+// Union sets s to the union of the ss.
+// Unlike Intersect, s may be one of the ss.
+func (s *SparseSet) Union(ss ...*SparseSet) {
+	var r SparseSet
+	for _, t := range ss {
+		r.UnionWith(t)
+	}
+	*s = r
+}
+
+// Difference sets s to the elements of ss[0] that are in none of ss[1:].
+// s may be one of the ss.
+func (s *SparseSet) Difference(ss ...*SparseSet) {
+	var r SparseSet
+	if len(ss) > 0 {
+		r.UnionWith(ss[0])
+		for _, t := range ss[1:] {
+			if r.Empty() {
+				break
+			}
+			r.DifferenceWith(t)
+		}
+	}
+	*s = r
+}
+
+// SymmetricDifference sets s to the elements that are in an odd number of the
+// ss. With two arguments, that is the elements that are in exactly one of them.
+// s may be one of the ss.
+func (s *SparseSet) SymmetricDifference(ss ...*SparseSet) {
+	var r SparseSet
+	for _, t := range ss {
+		r.SymmetricDifferenceWith(t)
 	}
-	s.root = intersectNodes(nodes)
+	*s = r
 }
 
+// String returns the elements of s in braces, as formatted by %v. So if s
+// has more than a hundred elements, String elides the rest.
 func (s SparseSet) String() string {
-	if s.Empty() {
-		return "{}"
-	}
-	els := make([]uint64, s.Size())
-	s.Elements(els, 0)
-	var buf bytes.Buffer
-	fmt.Fprintf(&buf, "{%d", els[0])
-	for _, e := range els[1:] {
-		fmt.Fprintf(&buf, ", %d", e)
-	}
-	fmt.Fprint(&buf, "}")
-	return buf.String()
+	return fmt.Sprint(s)
 }
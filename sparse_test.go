@@ -1,12 +1,16 @@
 package bit
 
 import (
+	"math"
 	"math/rand"
 	"reflect"
 	"runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"testing"
+
+	"github.com/google/go-cmp/cmp"
 )
 
 func TestSparseBasics(t *testing.T) {
@@ -177,6 +181,150 @@ func TestIntersect(t *testing.T) {
 	}
 }
 
+func TestUnion(t *testing.T) {
+	for _, test := range []struct {
+		els1, els2, want []uint64
+	}{
+		{nil, nil, nil},
+		{set(9), nil, set(9)},
+		{nil, set(9), set(9)},
+		{set(9), set(9), set(9)},
+		{set(9), set(9, 10), set(9, 10)},
+		{set(9, 99, 1e8), set(99, 1e8+1), set(9, 99, 1e8, 1e8+1)},
+	} {
+		s1 := NewSparseSet(test.els1...)
+		s2 := NewSparseSet(test.els2...)
+		want := NewSparseSet(test.want...)
+		var got SparseSet
+		got.Union(s1, s2)
+		if !got.Equal(want) {
+			t.Errorf("%s | %s = %v, want %v", s1, s2, got, want)
+		}
+		s1.UnionWith(s2)
+		if !s1.Equal(want) {
+			t.Errorf("UnionWith: got %v, want %v", s1, want)
+		}
+		// The result must not share structure with s2.
+		s1.Add(1e9)
+		if s2.Contains(1e9) {
+			t.Error("UnionWith: s2 modified")
+		}
+	}
+}
+
+func TestDifference(t *testing.T) {
+	for _, test := range []struct {
+		els1, els2, want []uint64
+	}{
+		{nil, nil, nil},
+		{set(9), nil, set(9)},
+		{nil, set(9), nil},
+		{set(9), set(9), nil},
+		{set(9, 10), set(9), set(10)},
+		{set(9, 99, 1e8), set(99, 1e8+1), set(9, 1e8)},
+	} {
+		s1 := NewSparseSet(test.els1...)
+		s2 := NewSparseSet(test.els2...)
+		want := NewSparseSet(test.want...)
+		var got SparseSet
+		got.Difference(s1, s2)
+		if !got.Equal(want) {
+			t.Errorf("%s - %s = %v, want %v", s1, s2, got, want)
+		}
+		s1.DifferenceWith(s2)
+		if !s1.Equal(want) {
+			t.Errorf("DifferenceWith: got %v, want %v", s1, want)
+		}
+	}
+}
+
+func TestSymmetricDifference(t *testing.T) {
+	for _, test := range []struct {
+		els1, els2, want []uint64
+	}{
+		{nil, nil, nil},
+		{set(9), nil, set(9)},
+		{nil, set(9), set(9)},
+		{set(9), set(9), nil},
+		{set(9, 10), set(9), set(10)},
+		{set(9, 99, 1e8), set(99, 1e8+1), set(9, 1e8, 1e8+1)},
+	} {
+		s1 := NewSparseSet(test.els1...)
+		s2 := NewSparseSet(test.els2...)
+		want := NewSparseSet(test.want...)
+		var got SparseSet
+		got.SymmetricDifference(s1, s2)
+		if !got.Equal(want) {
+			t.Errorf("%s ^ %s = %v, want %v", s1, s2, got, want)
+		}
+		s1.SymmetricDifferenceWith(s2)
+		if !s1.Equal(want) {
+			t.Errorf("SymmetricDifferenceWith: got %v, want %v", s1, want)
+		}
+	}
+}
+
+func TestSetOpsRandom(t *testing.T) {
+	// Compare the tree operations against a map-based implementation.
+	randSet := func() (*SparseSet, map[uint64]bool) {
+		s := &SparseSet{}
+		m := map[uint64]bool{}
+		for i := 0; i < 500; i++ {
+			// Use a small range so the sets overlap.
+			e := uint64(rand.Intn(5000))
+			if i%10 == 0 {
+				e = randUint64()
+			}
+			s.Add(e)
+			m[e] = true
+		}
+		return s, m
+	}
+	check := func(op string, got *SparseSet, want map[uint64]bool) {
+		t.Helper()
+		if got.Size() != len(want) {
+			t.Fatalf("%s: size %d, want %d", op, got.Size(), len(want))
+		}
+		for e := range want {
+			if !got.Contains(e) {
+				t.Fatalf("%s: missing %d", op, e)
+			}
+		}
+	}
+	for i := 0; i < 10; i++ {
+		s1, m1 := randSet()
+		s2, m2 := randSet()
+		s3, m3 := randSet()
+		union := map[uint64]bool{}
+		diff := map[uint64]bool{}
+		xor := map[uint64]bool{}
+		for _, m := range []map[uint64]bool{m1, m2, m3} {
+			for e := range m {
+				union[e] = true
+				if m1[e] && !m2[e] && !m3[e] {
+					diff[e] = true
+				}
+				n := 0
+				for _, mm := range []map[uint64]bool{m1, m2, m3} {
+					if mm[e] {
+						n++
+					}
+				}
+				if n%2 == 1 {
+					xor[e] = true
+				}
+			}
+		}
+		var got SparseSet
+		got.Union(s1, s2, s3)
+		check("union", &got, union)
+		got.Difference(s1, s2, s3)
+		check("difference", &got, diff)
+		got.SymmetricDifference(s1, s2, s3)
+		check("symmetric difference", &got, xor)
+	}
+}
+
 // func TestConsecutive(t *testing.T) {
 // 	for _, start := range []uint64{0, 100, 1e8} {
 // 		for _, sz := range []int{0, 1, 2, 3, 4, 5, 64, 256, 512, 1000, 10000, 100000} {
@@ -200,3 +348,568 @@ func TestIntersect(t *testing.T) {
 // 	}
 // 	fmt.Printf("consec: size=%d, bytes=%d\n", s.Size(), s.MemSize())
 // }
+
+func TestRankSelect(t *testing.T) {
+	var s SparseSet
+	if s.Rank(10) != 0 {
+		t.Error("empty set: nonzero rank")
+	}
+	if _, ok := s.Select(0); ok {
+		t.Error("empty set: Select succeeded")
+	}
+	nums := make([]uint64, 1000)
+	for i := range nums {
+		nums[i] = randUint64()
+		if i%2 == 0 {
+			nums[i] = uint64(rand.Intn(3000))
+		}
+		s.Add(nums[i])
+	}
+	els := make([]uint64, s.Size())
+	s.Elements(els, 0)
+	for k, e := range els {
+		if got := s.Rank(e); got != k {
+			t.Fatalf("Rank(%d) = %d, want %d", e, got, k)
+		}
+		if got := s.Rank(e + 1); got != k+1 && e+1 != 0 {
+			t.Fatalf("Rank(%d) = %d, want %d", e+1, got, k+1)
+		}
+		got, ok := s.Select(k)
+		if !ok || got != e {
+			t.Fatalf("Select(%d) = %d, %t, want %d, true", k, got, ok, e)
+		}
+	}
+	if _, ok := s.Select(len(els)); ok {
+		t.Error("Select past end succeeded")
+	}
+	if _, ok := s.Select(-1); ok {
+		t.Error("Select(-1) succeeded")
+	}
+}
+
+func BenchmarkSparseElements(b *testing.B) {
+	a := make([]uint64, 1<<16)
+	for _, d := range densities {
+		var s SparseSet
+		for i, w := range densitySet64s(d.shift) {
+			w.Each(func(u uint8) bool {
+				s.Add(uint64(i*64) + uint64(u))
+				return true
+			})
+		}
+		b.Run(d.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				s.Elements(a, 0)
+			}
+		})
+	}
+}
+
+func TestFromSorted(t *testing.T) {
+	var els []uint64
+	for i := 0; i < 3000; i++ {
+		els = append(els, randUint64(), uint64(rand.Intn(10000)))
+	}
+	els = append(els, els[:100]...) // duplicates
+	for i := uint64(0); i < 3*65536; i++ {
+		els = append(els, 1<<40+i) // full subtrees
+	}
+	var want SparseSet
+	for _, e := range els {
+		want.Add(e)
+	}
+	sorted := append([]uint64(nil), els...)
+	sort.Sort(uslice(sorted))
+	got := NewSparseSetFromSorted(sorted)
+	if !got.Equal(&want) || got.Size() != want.Size() {
+		t.Fatal("NewSparseSetFromSorted: wrong elements")
+	}
+	if got.MemSize() > want.MemSize() {
+		t.Errorf("MemSize = %d, want at most %d", got.MemSize(), want.MemSize())
+	}
+	if !NewSparseSet(els...).Equal(&want) {
+		t.Error("NewSparseSet: wrong elements")
+	}
+	if !NewSparseSetFromSorted(nil).Empty() {
+		t.Error("not empty")
+	}
+
+	// AddMany into a non-empty set.
+	s := NewSparseSet(els[:10]...)
+	s.AddMany(els[10:]...)
+	if !s.Equal(&want) || s.Size() != want.Size() {
+		t.Error("AddMany: wrong elements")
+	}
+	// AddMany takes over the tree it builds, instead of copying it into s.
+	built := testing.AllocsPerRun(5, func() {
+		NewSparseSet(1 << 63)
+		NewSparseSetFromSorted(sorted)
+	})
+	added := testing.AllocsPerRun(5, func() {
+		NewSparseSet(1 << 63).AddMany(sorted...)
+	})
+	if added > built*1.01 {
+		t.Errorf("AddMany made %.0f allocations, building alone made %.0f", added, built)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("no panic for unsorted input")
+		}
+	}()
+	NewSparseSetFromSorted([]uint64{2, 1})
+}
+
+func BenchmarkBuild(b *testing.B) {
+	els := make([]uint64, 1<<20)
+	for i := range els {
+		els[i] = uint64(i) * 37
+	}
+	b.Run("Add", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var s SparseSet
+			for _, e := range els {
+				s.Add(e)
+			}
+		}
+	})
+	b.Run("FromSorted", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			NewSparseSetFromSorted(els)
+		}
+	})
+}
+
+func TestSubnodeCapacity(t *testing.T) {
+	// Elements 256 apart are in different leaves of the same node.
+	var s SparseSet
+	for i := uint64(0); i < 256; i++ {
+		s.Add(i << 8)
+	}
+	n := s.root
+	for n.shift > 8 {
+		n = n.subnodes[0].sub.(*node)
+	}
+	if len(n.subnodes) != 256 || cap(n.subnodes) > 256 {
+		t.Fatalf("len %d, cap %d", len(n.subnodes), cap(n.subnodes))
+	}
+	for i := uint64(0); i < 250; i++ {
+		s.Remove(i << 8)
+	}
+	if l, c := len(n.subnodes), cap(n.subnodes); l != 6 || c > 4*l {
+		t.Errorf("after removal: len %d, cap %d", l, c)
+	}
+	for i := uint64(250); i < 256; i++ {
+		if !s.Contains(i << 8) {
+			t.Errorf("missing %d", i<<8)
+		}
+	}
+
+	s2 := randSparseSet(3000)
+	before := s2.MemSize()
+	want := s2.Copy()
+	s2.Compact()
+	if after := s2.MemSize(); after > before {
+		t.Errorf("Compact increased MemSize from %d to %d", before, after)
+	}
+	if !s2.Equal(want) {
+		t.Error("Compact changed the elements")
+	}
+	s2.Add(12345)
+	if !s2.Contains(12345) {
+		t.Error("Add after Compact failed")
+	}
+}
+
+func BenchmarkInsertDelete(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	els := make([]uint64, 1<<16)
+	for i := range els {
+		// Spread the elements so that most insertions create a subnode.
+		els[i] = uint64(r.Int63n(1 << 32))
+	}
+	b.Run("Insert", func(b *testing.B) {
+		var s SparseSet
+		for i := 0; i < b.N; i++ {
+			s.Clear()
+			for _, e := range els {
+				s.Add(e)
+			}
+		}
+		b.ReportMetric(float64(s.MemSize()), "bytes")
+		s.Compact()
+		b.ReportMetric(float64(s.MemSize()), "compacted-bytes")
+	})
+	b.Run("Delete", func(b *testing.B) {
+		var mem uint64
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			s := NewSparseSet(els...)
+			b.StartTimer()
+			for _, e := range els[:len(els)-len(els)/16] {
+				s.Remove(e)
+			}
+			mem = s.MemSize()
+		}
+		b.ReportMetric(float64(mem), "bytes")
+	})
+}
+
+func TestTreeHeight(t *testing.T) {
+	height := func(s *SparseSet) int { return len(s.Stats().Levels) }
+	var s SparseSet
+	s.Add(3)
+	if h := height(&s); h != 1 {
+		t.Errorf("{3}: height %d, want 1", h)
+	}
+	s.Add(1<<32 - 1)
+	if h := height(&s); h != 3 {
+		t.Errorf("32-bit: height %d, want 3", h)
+	}
+	s.Add(1 << 63)
+	if h := height(&s); h != 7 {
+		t.Errorf("64-bit: height %d, want 7", h)
+	}
+	s.Remove(1 << 63)
+	if h := height(&s); h != 3 {
+		t.Errorf("after Remove: height %d, want 3", h)
+	}
+	if !s.Equal(NewSparseSet(3, 1<<32-1)) {
+		t.Errorf("got %s", s)
+	}
+
+	// Queries beyond the largest element the root can hold.
+	const big = 1 << 40
+	if s.Contains(big) || s.Contains(1<<32) {
+		t.Error("Contains out of range")
+	}
+	s.Remove(big)
+	s.RemoveRange(1<<32, big)
+	if got := s.Size(); got != 2 {
+		t.Errorf("Size = %d", got)
+	}
+	if got := s.Rank(big); got != 2 {
+		t.Errorf("Rank(big) = %d", got)
+	}
+	if got := s.Count(0, big); got != 2 {
+		t.Errorf("Count = %d", got)
+	}
+	if s.ContainsAll(1<<32-1, 1<<32) {
+		t.Error("ContainsAll out of range")
+	}
+	if _, ok := s.NextAfter(1<<32 - 1); ok {
+		t.Error("NextAfter the largest element")
+	}
+	if got, _ := s.PrevBefore(big); got != 1<<32-1 {
+		t.Errorf("PrevBefore(big) = %d", got)
+	}
+	if got := s.Elements(make([]uint64, 2), big); got != 0 {
+		t.Errorf("Elements(big) = %d", got)
+	}
+	it := s.Iterator()
+	it.Seek(big)
+	if _, ok := it.Next(); ok {
+		t.Error("iterator past the end")
+	}
+	if got := drain(s.ReverseIterator()); !cmp.Equal(got, []uint64{1<<32 - 1, 3}) {
+		t.Errorf("ReverseIterator: %v", got)
+	}
+	s.AddRange(big, big+1)
+	s.FlipRange(big, big)
+	if got := s.Count(0, math.MaxUint64); got != 3 || !s.Contains(big+1) {
+		t.Errorf("after AddRange and FlipRange: %s", s)
+	}
+}
+
+func TestMixedHeights(t *testing.T) {
+	// Binary operations between trees of different heights.
+	sets := []*SparseSet{
+		NewSparseSet(1, 2, 200),
+		NewSparseSet(2, 70000, 1<<31),
+		NewSparseSet(1, 200, 1<<31, 1<<60),
+		rangeSet(0, 1<<17),
+		NewSparseSet(1<<60+2, 1<<60+300),
+		NewSparseSet(1<<60, 1<<60+70000, math.MaxUint64),
+		rangeSet(1<<60+100, 1<<60+1000),
+	}
+	toMap := func(s *SparseSet) map[uint64]bool {
+		m := map[uint64]bool{}
+		for e := range s.All() {
+			m[e] = true
+		}
+		return m
+	}
+	check := func(name string, got *SparseSet, want func(a, b bool) bool, s1, s2 *SparseSet) {
+		t.Helper()
+		m1, m2 := toMap(s1), toMap(s2)
+		wantSet := &SparseSet{}
+		for _, m := range []map[uint64]bool{m1, m2} {
+			for e := range m {
+				if want(m1[e], m2[e]) {
+					wantSet.Add(e)
+				}
+			}
+		}
+		if !got.Equal(wantSet) || got.Size() != wantSet.Size() {
+			t.Errorf("%s(%s, %s): got %s, want %s", name, s1, s2, got, wantSet)
+		}
+		if !got.Equal(NewSparseSetFromSorted(slices.Collect(got.All()))) {
+			t.Errorf("%s: not equal to the same elements built from scratch", name)
+		}
+	}
+	for _, s1 := range sets {
+		for _, s2 := range sets {
+			u := s1.Copy()
+			u.UnionWith(s2)
+			check("UnionWith", u, func(a, b bool) bool { return a || b }, s1, s2)
+			d := s1.Copy()
+			d.DifferenceWith(s2)
+			check("DifferenceWith", d, func(a, b bool) bool { return a && !b }, s1, s2)
+			x := s1.Copy()
+			x.SymmetricDifferenceWith(s2)
+			check("SymmetricDifferenceWith", x, func(a, b bool) bool { return a != b }, s1, s2)
+			var i SparseSet
+			i.Intersect(s1, s2)
+			check("Intersect", &i, func(a, b bool) bool { return a && b }, s1, s2)
+			if got, want := s1.IntersectionSize(s2), i.Size(); got != want {
+				t.Errorf("IntersectionSize(%s, %s) = %d, want %d", s1, s2, got, want)
+			}
+			if got, want := s1.UnionSize(s2), u.Size(); got != want {
+				t.Errorf("UnionSize(%s, %s) = %d, want %d", s1, s2, got, want)
+			}
+			if got, want := s1.IsSubsetOf(s2), d.Empty(); got != want {
+				t.Errorf("%s.IsSubsetOf(%s) = %t, want %t", s1, s2, got, want)
+			}
+			if got, want := s1.Intersects(s2), !i.Empty(); got != want {
+				t.Errorf("%s.Intersects(%s) = %t, want %t", s1, s2, got, want)
+			}
+
+			p1, p2 := s1.Persistent(), s2.Persistent()
+			check("PersistentSet.Union", p1.Union(p2).SparseSet(), func(a, b bool) bool { return a || b }, s1, s2)
+			check("PersistentSet.Intersect", p1.Intersect(p2).SparseSet(), func(a, b bool) bool { return a && b }, s1, s2)
+		}
+	}
+}
+
+func TestIntersectionSize(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	randSet := func(n int, lim uint64) *SparseSet {
+		s := &SparseSet{}
+		for i := 0; i < n; i++ {
+			s.Add(r.Uint64() % lim)
+		}
+		return s
+	}
+	for i := 0; i < 100; i++ {
+		lim := uint64(1) << (8 + r.Intn(20))
+		s1, s2 := randSet(r.Intn(2000), lim), randSet(r.Intn(2000), lim)
+		if i%3 == 0 {
+			s1.AddRange(lim/4, lim/2)
+		}
+		var want SparseSet
+		want.Intersect(s1, s2)
+		if got := s1.IntersectionSize(s2); got != want.Size() {
+			t.Fatalf("IntersectionSize = %d, want %d", got, want.Size())
+		}
+		if got := s2.IntersectionSize(s1); got != want.Size() {
+			t.Fatalf("reversed IntersectionSize = %d, want %d", got, want.Size())
+		}
+		if got, want := s1.UnionSize(s2), s1.Size()+s2.Size()-want.Size(); got != want {
+			t.Fatalf("UnionSize = %d, want %d", got, want)
+		}
+	}
+	var empty SparseSet
+	s := NewSparseSet(1, 2, 3)
+	if got := s.IntersectionSize(&empty); got != 0 {
+		t.Errorf("with empty: %d", got)
+	}
+	if got := s.IntersectionSize(s); got != 3 {
+		t.Errorf("with itself: %d", got)
+	}
+	if got := s.UnionSize(&empty); got != 3 {
+		t.Errorf("UnionSize with empty: %d", got)
+	}
+}
+
+func TestSubsetPredicatesSparse(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		lim := uint64(1) << (8 + r.Intn(24))
+		s1, s2 := &SparseSet{}, &SparseSet{}
+		for j := r.Intn(500); j > 0; j-- {
+			s2.Add(r.Uint64() % lim)
+		}
+		if i%4 == 0 {
+			s2.AddRange(lim/4, lim/4+1000)
+		}
+		// Make s1 mostly a subset of s2, sometimes with one extra element.
+		for e := range s2.All() {
+			if r.Intn(3) == 0 {
+				s1.Add(e)
+			}
+		}
+		if i%2 == 0 {
+			s1.Add(r.Uint64() % (2 * lim))
+		}
+		var d, in SparseSet
+		d.Difference(s1, s2)
+		in.Intersect(s1, s2)
+		if got, want := s1.IsSubsetOf(s2), d.Empty(); got != want {
+			t.Fatalf("IsSubsetOf = %t, want %t", got, want)
+		}
+		if got, want := s2.IsSupersetOf(s1), d.Empty(); got != want {
+			t.Fatalf("IsSupersetOf = %t, want %t", got, want)
+		}
+		if got, want := s1.Intersects(s2), !in.Empty(); got != want {
+			t.Fatalf("Intersects = %t, want %t", got, want)
+		}
+		if got, want := s2.IsDisjoint(s1), in.Empty(); got != want {
+			t.Fatalf("IsDisjoint = %t, want %t", got, want)
+		}
+	}
+	var empty SparseSet
+	s := NewSparseSet(5)
+	if !empty.IsSubsetOf(s) || s.IsSubsetOf(&empty) || !empty.IsSubsetOf(&empty) {
+		t.Error("IsSubsetOf with an empty set")
+	}
+	if s.Intersects(&empty) || empty.Intersects(s) {
+		t.Error("Intersects with an empty set")
+	}
+	// s1's tree is taller than s2's, but its elements outside s2's range
+	// are what matter.
+	s1, s2 := NewSparseSet(5, 1<<40), rangeSet(0, 300)
+	if s1.IsSubsetOf(s2) || !s1.Intersects(s2) || !NewSparseSet(5).IsSubsetOf(s2) {
+		t.Error("mixed heights")
+	}
+}
+
+func BenchmarkIntersects(b *testing.B) {
+	// Two sets of group IDs that overlap only in their last element.
+	s1, s2 := &SparseSet{}, &SparseSet{}
+	for i := uint64(0); i < 1000; i++ {
+		s1.Add(i * 1000)
+		s2.Add(i*1000 + 1)
+	}
+	s1.Add(1 << 30)
+	s2.Add(1 << 30)
+	for i := 0; i < b.N; i++ {
+		s1.Intersects(s2)
+	}
+}
+
+func BenchmarkIntersectionSize(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	s1, s2 := &SparseSet{}, &SparseSet{}
+	for i := 0; i < 1e5; i++ {
+		s1.Add(uint64(r.Intn(1 << 20)))
+		s2.Add(uint64(r.Intn(1 << 20)))
+	}
+	b.Run("IntersectionSize", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			s1.IntersectionSize(s2)
+		}
+	})
+	b.Run("Intersect", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var s SparseSet
+			s.Intersect(s1, s2)
+			_ = s.Size()
+		}
+	})
+}
+
+func TestPrefix(t *testing.T) {
+	// Elements clustered far from zero need no more levels than those
+	// near zero.
+	const base = 1<<60 + 1<<40
+	els := []uint64{base + 3, base + 300, base + 70000}
+	s := NewSparseSet(els...)
+	if h := len(s.Stats().Levels); h != 2 {
+		t.Errorf("height %d, want 2", h)
+	}
+	for _, e := range []uint64{0, 3, 1 << 60, base, base + 4, base + 1<<24, 1 << 63} {
+		if s.Contains(e) {
+			t.Errorf("Contains(%#x)", e)
+		}
+	}
+	if got := slices.Collect(s.All()); !cmp.Equal(got, els) {
+		t.Errorf("All: got %v", got)
+	}
+	if got := slices.Collect(s.Backward()); !cmp.Equal(got, []uint64{els[2], els[1], els[0]}) {
+		t.Errorf("Backward: got %v", got)
+	}
+	if got := slices.Collect(s.From(base + 4)); !cmp.Equal(got, els[1:]) {
+		t.Errorf("From: got %v", got)
+	}
+	if got := slices.Collect(s.From(3)); !cmp.Equal(got, els) {
+		t.Errorf("From below the prefix: got %v", got)
+	}
+	if got := drain(s.ReverseIterator()); !cmp.Equal(got, []uint64{els[2], els[1], els[0]}) {
+		t.Errorf("ReverseIterator: got %v", got)
+	}
+	a := make([]uint64, 5)
+	if n := s.Elements(a, 0); !cmp.Equal(a[:n], els) {
+		t.Errorf("Elements: got %v", a[:n])
+	}
+	if lo, _ := s.Min(); lo != els[0] {
+		t.Errorf("Min = %#x", lo)
+	}
+	if hi, _ := s.Max(); hi != els[2] {
+		t.Errorf("Max = %#x", hi)
+	}
+	if e, ok := s.NextAfter(0); !ok || e != els[0] {
+		t.Errorf("NextAfter(0) = %#x, %t", e, ok)
+	}
+	if e, ok := s.PrevBefore(math.MaxUint64); !ok || e != els[2] {
+		t.Errorf("PrevBefore(max) = %#x, %t", e, ok)
+	}
+	if _, ok := s.PrevBefore(base); ok {
+		t.Error("PrevBefore(base) found an element")
+	}
+	if e, ok := s.Select(1); !ok || e != els[1] {
+		t.Errorf("Select(1) = %#x, %t", e, ok)
+	}
+	if r := s.Rank(base + 300); r != 1 {
+		t.Errorf("Rank = %d", r)
+	}
+	if r := s.Rank(math.MaxUint64); r != 3 {
+		t.Errorf("Rank(max) = %d", r)
+	}
+	if c := s.Count(0, base+300); c != 2 {
+		t.Errorf("Count = %d", c)
+	}
+	if s.ContainsAll(0, base+3) {
+		t.Error("ContainsAll below the prefix")
+	}
+	s.RemoveRange(0, base+3)
+	if got := s.Size(); got != 2 {
+		t.Errorf("after RemoveRange: %s", s)
+	}
+
+	// Adding an element with a different prefix grows the tree, and
+	// removing it shrinks the tree again.
+	s.Add(5)
+	if h := len(s.Stats().Levels); h != 7 {
+		t.Errorf("with 5: height %d, want 7", h)
+	}
+	s.Remove(5)
+	if h := len(s.Stats().Levels); h != 2 {
+		t.Errorf("without 5: height %d, want 2", h)
+	}
+	if !s.Equal(NewSparseSet(els[1:]...)) {
+		t.Errorf("got %s", s)
+	}
+}
+
+func BenchmarkContains32(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	els := make([]uint64, 1<<16)
+	for i := range els {
+		els[i] = uint64(r.Uint32())
+	}
+	s := NewSparseSet(els...)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Contains(els[i%len(els)])
+	}
+}
@@ -0,0 +1,108 @@
+package bit
+
+import "sort"
+
+// sizeClasses are the object sizes of the Go allocator's small size classes,
+// from runtime/sizeclasses.go. Larger objects are rounded up to a whole
+// number of pages.
+var sizeClasses = []uint64{
+	8, 16, 24, 32, 48, 64, 80, 96, 112, 128, 144, 160, 176, 192, 208, 224,
+	240, 256, 288, 320, 352, 384, 416, 448, 480, 512, 576, 640, 704, 768, 896,
+	1024, 1152, 1280, 1408, 1536, 1792, 2048, 2304, 2688, 3072, 3200, 3456,
+	4096, 4864, 5376, 6144, 6528, 6784, 6912, 8192, 9472, 9728, 10240, 10880,
+	12288, 13568, 14336, 16384, 18432, 19072, 20480, 21760, 24576, 27264,
+	28672, 32768,
+}
+
+const pageSize = 8192
+
+// allocSize returns the number of bytes the Go allocator uses for an object
+// of n bytes.
+func allocSize(n uint64) uint64 {
+	if n == 0 {
+		return 0
+	}
+	if n > sizeClasses[len(sizeClasses)-1] {
+		return (n + pageSize - 1) / pageSize * pageSize
+	}
+	return sizeClasses[sort.Search(len(sizeClasses), func(i int) bool { return sizeClasses[i] >= n })]
+}
+
+// TreeStats describes the shape and memory use of a SparseSet's tree.
+type TreeStats struct {
+	// Levels describes the interior nodes at each depth, starting with the
+	// root, down to the parents of the leaves. The nodes at depth i are 8*i
+	// bits below the root, so a depth that chains skip over may have no
+	// nodes.
+	Levels []LevelStats
+	// Leaves is the number of leaves. Each leaf holds up to 256 elements.
+	Leaves int
+	// LeafFill is the average fraction of a leaf's 256 elements that are
+	// present, or 0 if there are no leaves.
+	LeafFill float64
+	// WastedBytes is the part of MemSize that holds no data: unused subnode
+	// capacity, and the difference between the size of each object and the
+	// size the allocator rounds it up to.
+	WastedBytes uint64
+	// MemSize is the same as the set's MemSize.
+	MemSize uint64
+}
+
+// LevelStats describes the interior nodes at one depth of a SparseSet's tree.
+type LevelStats struct {
+	Nodes    int // number of nodes
+	Children int // number of children of the nodes
+	Capacity int // total capacity of the nodes' subnode slices
+	// Full is the number of children that are full subtrees. A full subtree
+	// holds every element in its range, and takes no memory.
+	Full int
+	// Chains is the number of children that are chains. A chain stands for a
+	// path of nodes with a single subnode each, and leads to a node, a leaf
+	// or a full subtree, which is counted as if it were the child.
+	Chains int
+}
+
+// Stats returns statistics about the tree that represents s.
+// It takes time proportional to the number of nodes in the tree.
+func (s *SparseSet) Stats() TreeStats {
+	st := TreeStats{MemSize: s.MemSize()}
+	if s.root == nil {
+		return st
+	}
+	st.Levels = make([]LevelStats, s.root.shift/8)
+	var leafElements int
+	var walk func(n *node)
+	walk = func(n *node) {
+		ls := &st.Levels[(s.root.shift-n.shift)/8]
+		ls.Nodes++
+		ls.Children += len(n.subnodes)
+		ls.Capacity += cap(n.subnodes)
+		nsize := memSize(*n)
+		ssize := uint64(len(n.subnodes)) * memSize(subnode{})
+		st.WastedBytes += allocSize(nsize) - nsize
+		st.WastedBytes += allocSize(uint64(cap(n.subnodes))*memSize(subnode{})) - ssize
+		for _, sn := range n.subnodes {
+			sub := sn.sub
+			if c, ok := sub.(*chain); ok {
+				ls.Chains++
+				csize := memSize(*c)
+				st.WastedBytes += allocSize(csize) - csize
+				sub = c.sub
+			}
+			switch sub := sub.(type) {
+			case *node:
+				walk(sub)
+			case *Set256:
+				st.Leaves++
+				leafElements += sub.Size()
+			case full:
+				ls.Full++
+			}
+		}
+	}
+	walk(s.root)
+	if st.Leaves > 0 {
+		st.LeafFill = float64(leafElements) / float64(256*st.Leaves)
+	}
+	return st
+}
@@ -0,0 +1,126 @@
+package bit
+
+import (
+	"runtime"
+	"slices"
+	"testing"
+)
+
+func TestAllocSize(t *testing.T) {
+	for _, test := range []struct{ in, want uint64 }{
+		{0, 0}, {1, 8}, {8, 8}, {9, 16}, {72, 80}, {6144, 6144}, {6145, 6528},
+		{32768, 32768}, {32769, 40960},
+	} {
+		if got := allocSize(test.in); got != test.want {
+			t.Errorf("allocSize(%d) = %d, want %d", test.in, got, test.want)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	var empty SparseSet
+	if st := empty.Stats(); st.Leaves != 0 || len(st.Levels) != 0 || st.MemSize != empty.MemSize() {
+		t.Errorf("empty: %+v", st)
+	}
+
+	s := NewSparseSet(1, 2, 300, 1<<40)
+	s.AddRange(1<<50, 1<<50+1<<16-1) // a full subtree at the end of a chain
+	st := s.Stats()
+	if got, want := len(st.Levels), 6; got != want {
+		t.Fatalf("got %d levels, want %d", got, want)
+	}
+	if l := st.Levels[0]; l.Nodes != 1 || l.Children != 2 || l.Full != 1 || l.Chains != 1 {
+		t.Errorf("root: %+v", l)
+	}
+	// Chains lead from level 1 to the leaf of 1<<40 and to the node at
+	// level 5, skipping the levels between.
+	if l := st.Levels[1]; l.Nodes != 1 || l.Children != 2 || l.Chains != 2 {
+		t.Errorf("level 1: %+v", l)
+	}
+	for i := 2; i < 5; i++ {
+		if l := st.Levels[i]; l != (LevelStats{}) {
+			t.Errorf("level %d: %+v", i, l)
+		}
+	}
+	if l := st.Levels[5]; l.Nodes != 1 || l.Children != 2 || l.Chains != 0 {
+		t.Errorf("level 5: %+v", l)
+	}
+	if st.Leaves != 3 || st.LeafFill != 4.0/(3*256) {
+		t.Errorf("Leaves = %d, LeafFill = %g", st.Leaves, st.LeafFill)
+	}
+	if st.MemSize != s.MemSize() || st.WastedBytes >= st.MemSize {
+		t.Errorf("MemSize = %d, WastedBytes = %d", st.MemSize, st.WastedBytes)
+	}
+	// Adding a subnode leaves room in the subnode slice for more.
+	s.Add(600)
+	if st := s.Stats(); st.MemSize != s.MemSize() || st.WastedBytes == 0 || st.WastedBytes >= st.MemSize {
+		t.Errorf("after Add: MemSize = %d, WastedBytes = %d", st.MemSize, st.WastedBytes)
+	}
+}
+
+func TestMemSizeAccuracy(t *testing.T) {
+	// MemSize should be close to what the runtime actually allocates.
+	els := make([]uint64, 50000)
+	for i := range els {
+		els[i] = randUint64() >> 24
+	}
+	var ms runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&ms)
+	before := ms.HeapAlloc
+	s := &SparseSet{}
+	for _, e := range els {
+		s.Add(e)
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&ms)
+	heap := ms.HeapAlloc - before
+	got := s.MemSize()
+	if got < heap*95/100 || got > heap*105/100 {
+		t.Errorf("MemSize = %d, but the heap grew by %d", got, heap)
+	}
+	runtime.KeepAlive(s)
+	// Otherwise els could be freed while s is built, which hides its size.
+	runtime.KeepAlive(els)
+}
+
+func TestMemSizeFromSorted(t *testing.T) {
+	// A set built all at once uses the same memory as one built an element
+	// at a time and then compacted, and MemSize accounts for it, even after
+	// most of the leaves are removed.
+	els := make([]uint64, 200000)
+	for i := range els {
+		els[i] = randUint64() >> 40
+	}
+	slices.Sort(els)
+	var ms runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&ms)
+	before := ms.HeapAlloc
+	s := NewSparseSetFromSorted(els)
+	a := &SparseSet{}
+	for _, e := range els {
+		a.Add(e)
+	}
+	a.Compact()
+	if s.MemSize() != a.MemSize() {
+		t.Errorf("MemSize = %d, want %d as for Add", s.MemSize(), a.MemSize())
+	}
+	a = nil
+
+	// Keep only the first four leaves below each node at shift 8.
+	for lo := uint64(0); lo < 1<<24; lo += 1 << 16 {
+		s.RemoveRange(lo+1024, lo+1<<16-1)
+	}
+	s.Compact()
+	runtime.GC()
+	runtime.ReadMemStats(&ms)
+	// The heap may have shrunk, if memory in use before was freed.
+	heap := int64(ms.HeapAlloc) - int64(before)
+	got := int64(s.MemSize())
+	if heap > got*2 {
+		t.Errorf("MemSize = %d, but the heap grew by %d", got, heap)
+	}
+	runtime.KeepAlive(s)
+	runtime.KeepAlive(els)
+}
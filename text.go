@@ -0,0 +1,235 @@
+package bit
+
+import (
+	"fmt"
+	"iter"
+	"strconv"
+	"strings"
+)
+
+// The text form of a set is a comma-separated list of its elements in braces,
+// like the output of %+v: {1, 2, 3}. The list may also contain ranges of
+// consecutive elements, written lo-hi: {1-100, 205, 300-310}.
+//
+// The Parse functions and UnmarshalText methods accept elements and ranges
+// in any order, and allow them to repeat and overlap. The braces and spaces
+// are optional, so "1-100,205" is also accepted, which is convenient in
+// flags. MarshalText writes the elements in increasing order, with each run
+// of two or more consecutive elements as a range, which is always shorter
+// than listing them.
+
+// ParseSet64 returns the Set64 whose text form is text.
+func ParseSet64(text string) (Set64, error) {
+	var s Set64
+	err := parseRanges(text, 63, func(lo, hi uint64) { s |= rangeMask(uint8(lo), uint8(hi)) })
+	if err != nil {
+		return 0, err
+	}
+	return s, nil
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (s Set64) MarshalText() ([]byte, error) {
+	return appendRanges(nil, mergeRuns(s.runs())), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+// If text is not valid, UnmarshalText returns an error and leaves s unchanged.
+func (s *Set64) UnmarshalText(text []byte) error {
+	t, err := ParseSet64(string(text))
+	if err != nil {
+		return err
+	}
+	*s = t
+	return nil
+}
+
+// ParseSet256 returns the Set256 whose text form is text.
+func ParseSet256(text string) (Set256, error) {
+	var s Set256
+	err := parseRanges(text, 255, func(lo, hi uint64) { s.AddRange(uint8(lo), uint8(hi)) })
+	if err != nil {
+		return Set256{}, err
+	}
+	return s, nil
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (s Set256) MarshalText() ([]byte, error) {
+	return appendRanges(nil, mergeRuns(s.runs())), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+// If text is not valid, UnmarshalText returns an error and leaves s unchanged.
+func (s *Set256) UnmarshalText(text []byte) error {
+	t, err := ParseSet256(string(text))
+	if err != nil {
+		return err
+	}
+	*s = t
+	return nil
+}
+
+// ParseSparseSet returns the SparseSet whose text form is text.
+// It adds each range at once, so a large range takes little time or memory.
+func ParseSparseSet(text string) (*SparseSet, error) {
+	s := &SparseSet{}
+	if err := parseRanges(text, 1<<64-1, s.AddRange); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// MarshalText implements encoding.TextMarshaler.
+// It writes a full subtree as a range without visiting its elements.
+func (s *SparseSet) MarshalText() ([]byte, error) {
+	return appendRanges(nil, mergeRuns(s.runs())), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+// If text is not valid, UnmarshalText returns an error and leaves s unchanged.
+func (s *SparseSet) UnmarshalText(text []byte) error {
+	t, err := ParseSparseSet(string(text))
+	if err != nil {
+		return err
+	}
+	*s = *t
+	return nil
+}
+
+// The runs methods return iterators over intervals of consecutive elements of
+// a set, in increasing order. Adjacent intervals may be consecutive; use
+// mergeRuns to join them.
+
+func (s Set64) runs() iter.Seq2[uint64, uint64] {
+	return func(yield func(lo, hi uint64) bool) {
+		s.Each(func(e uint8) bool { return yield(uint64(e), uint64(e)) })
+	}
+}
+
+func (s *Set256) runs() iter.Seq2[uint64, uint64] {
+	return func(yield func(lo, hi uint64) bool) {
+		s.Each(func(e uint8) bool { return yield(uint64(e), uint64(e)) })
+	}
+}
+
+func (s *Set) runs() iter.Seq2[uint64, uint64] {
+	return func(yield func(lo, hi uint64) bool) {
+		s.Each(func(e int) bool { return yield(uint64(e), uint64(e)) })
+	}
+}
+
+func (s *SparseSet) runs() iter.Seq2[uint64, uint64] {
+	return func(yield func(lo, hi uint64) bool) {
+		if s.root != nil {
+			runs(s.root, s.prefix, yield)
+		}
+	}
+}
+
+// runs calls yield on intervals of consecutive elements of sub, in increasing
+// order, until yield returns false. The high bits of the elements of sub are
+// high. runs reports whether it reached the end of sub.
+func runs(sub subber, high uint64, yield func(lo, hi uint64) bool) bool {
+	switch sub := sub.(type) {
+	case *Set256:
+		for e := range sub.All() {
+			if !yield(high|uint64(e), high|uint64(e)) {
+				return false
+			}
+		}
+	case full:
+		return yield(high, high|sub.mask())
+	case *chain:
+		return runs(sub.sub, high|sub.prefix, yield)
+	case *node:
+		for _, sn := range sub.subnodes {
+			if !runs(sn.sub, high|uint64(sn.index)<<sub.shift, yield) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// mergeRuns returns an iterator over the intervals of rs, with consecutive
+// intervals joined. The intervals of rs must be in increasing order.
+func mergeRuns(rs iter.Seq2[uint64, uint64]) iter.Seq2[uint64, uint64] {
+	return func(yield func(lo, hi uint64) bool) {
+		var lo, hi uint64
+		started := false
+		for rlo, rhi := range rs {
+			if started && rlo == hi+1 {
+				hi = rhi
+				continue
+			}
+			if started && !yield(lo, hi) {
+				return
+			}
+			lo, hi, started = rlo, rhi, true
+		}
+		if started {
+			yield(lo, hi)
+		}
+	}
+}
+
+// appendRanges appends the text form of the intervals of rs to b.
+func appendRanges(b []byte, rs iter.Seq2[uint64, uint64]) []byte {
+	b = append(b, '{')
+	sep := ""
+	for lo, hi := range rs {
+		b = append(b, sep...)
+		sep = ", "
+		b = strconv.AppendUint(b, lo, 10)
+		if hi > lo {
+			b = append(b, '-')
+			b = strconv.AppendUint(b, hi, 10)
+		}
+	}
+	return append(b, '}')
+}
+
+// parseRanges parses the text form of a set, calling add on each element or
+// range. The elements must be no greater than limit.
+func parseRanges(text string, limit uint64, add func(lo, hi uint64)) error {
+	t := strings.TrimSpace(text)
+	if strings.HasPrefix(t, "{") {
+		if len(t) < 2 || !strings.HasSuffix(t, "}") {
+			return fmt.Errorf("bit: set text %q has no closing brace", text)
+		}
+		t = strings.TrimSpace(t[1 : len(t)-1])
+	}
+	if t == "" {
+		return nil
+	}
+	for _, item := range strings.Split(t, ",") {
+		los, his, isRange := strings.Cut(item, "-")
+		lo, err := parseElement(los, limit)
+		if err != nil {
+			return err
+		}
+		hi := lo
+		if isRange {
+			if hi, err = parseElement(his, limit); err != nil {
+				return err
+			}
+			if lo > hi {
+				return fmt.Errorf("bit: set range %q is backwards", strings.TrimSpace(item))
+			}
+		}
+		add(lo, hi)
+	}
+	return nil
+}
+
+func parseElement(s string, limit uint64) (uint64, error) {
+	e, err := strconv.ParseUint(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bit: bad set element: %w", err)
+	}
+	if e > limit {
+		return 0, fmt.Errorf("bit: set element %d is greater than %d", e, limit)
+	}
+	return e, nil
+}
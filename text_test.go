@@ -0,0 +1,137 @@
+package bit
+
+import (
+	"encoding"
+	"fmt"
+	"math"
+	"testing"
+)
+
+var (
+	_ encoding.TextMarshaler   = Set64(0)
+	_ encoding.TextUnmarshaler = (*Set64)(nil)
+	_ encoding.TextMarshaler   = Set256{}
+	_ encoding.TextUnmarshaler = (*Set256)(nil)
+	_ encoding.TextMarshaler   = (*SparseSet)(nil)
+	_ encoding.TextUnmarshaler = (*SparseSet)(nil)
+)
+
+func TestParseSparseSet(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want *SparseSet
+	}{
+		{"{}", NewSparseSet()},
+		{"", NewSparseSet()},
+		{" { } ", NewSparseSet()},
+		{"{1, 2, 3}", NewSparseSet(1, 2, 3)},
+		{"3,1,2,1", NewSparseSet(1, 2, 3)},
+		{"{1-3}", NewSparseSet(1, 2, 3)},
+		{"{ 1 - 3 , 7 }", NewSparseSet(1, 2, 3, 7)},
+		{"{5-5}", NewSparseSet(5)},
+		{"{1-3, 2-4}", NewSparseSet(1, 2, 3, 4)},
+		{"{18446744073709551615}", NewSparseSet(math.MaxUint64)},
+	} {
+		got, err := ParseSparseSet(test.in)
+		if err != nil {
+			t.Errorf("%q: %v", test.in, err)
+			continue
+		}
+		if !got.Equal(test.want) {
+			t.Errorf("%q: got %s, want %s", test.in, got, test.want)
+		}
+	}
+	for _, in := range []string{
+		"{", "{1, 2", "1, 2}", "{,}", "{1,}", "{x}", "{-1}", "{1-}", "{3-1}",
+		"{1-2-3}", "{18446744073709551616}", "{0x10}",
+	} {
+		if got, err := ParseSparseSet(in); err == nil {
+			t.Errorf("%q: got %s, want error", in, got)
+		}
+	}
+}
+
+func TestMarshalText(t *testing.T) {
+	for _, test := range []struct {
+		s    *SparseSet
+		want string
+	}{
+		{NewSparseSet(), "{}"},
+		{NewSparseSet(7), "{7}"},
+		{NewSparseSet(1, 3), "{1, 3}"},
+		{NewSparseSet(1, 2), "{1-2}"},
+		{NewSparseSet(1, 2, 3, 205, 300, 301, 302), "{1-3, 205, 300-302}"},
+		{NewSparseSet(255, 256), "{255-256}"},
+		{rangeSet(1000, 1000+3<<16), "{1000-197607}"},
+		{NewSparseSet(math.MaxUint64-1, math.MaxUint64), "{18446744073709551614-18446744073709551615}"},
+	} {
+		got, err := test.s.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != test.want {
+			t.Errorf("got %s, want %s", got, test.want)
+		}
+		var u SparseSet
+		if err := u.UnmarshalText(got); err != nil {
+			t.Fatal(err)
+		}
+		if !u.Equal(test.s) {
+			t.Errorf("round trip: got %s, want %s", u, test.s)
+		}
+	}
+
+	// A huge range is written without visiting its elements.
+	var s SparseSet
+	s.AddRange(1<<40, 1<<50)
+	s.Add(3)
+	if got, _ := s.MarshalText(); string(got) != "{3, 1099511627776-1125899906842624}" {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestTextRoundTrip(t *testing.T) {
+	// %+v output parses back.
+	s := randSparseSet(200)
+	got, err := ParseSparseSet(fmt.Sprintf("%+v", s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(s) {
+		t.Errorf("got %s, want %s", got, s)
+	}
+
+	s64 := sampleSet64()
+	for _, text := range []string{s64.String(), "{3, 17, 63}"} {
+		var g Set64
+		if err := g.UnmarshalText([]byte(text)); err != nil || g != s64 {
+			t.Errorf("Set64 %q: got %s, %v", text, g, err)
+		}
+	}
+	if b, _ := Set64(0xf0f).MarshalText(); string(b) != "{0-3, 8-11}" {
+		t.Errorf("Set64: got %s", b)
+	}
+	if _, err := ParseSet64("{64}"); err == nil {
+		t.Error("Set64: no error for 64")
+	}
+
+	s256 := sampleSet256()
+	g256, err := ParseSet256(s256.String())
+	if err != nil || !g256.Equal(&s256) {
+		t.Errorf("Set256: got %s, %v", g256, err)
+	}
+	var r256 Set256
+	r256.AddRange(60, 200)
+	if b, _ := r256.MarshalText(); string(b) != "{60-200}" {
+		t.Errorf("Set256: got %s", b)
+	}
+	if _, err := ParseSet256("{1-256}"); err == nil {
+		t.Error("Set256: no error for 256")
+	}
+
+	// A failed unmarshal leaves the set unchanged.
+	s256.UnmarshalText([]byte("{1, x}"))
+	if want := sampleSet256(); !s256.Equal(&want) {
+		t.Errorf("Set256 changed to %s", s256)
+	}
+}